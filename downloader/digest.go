@@ -0,0 +1,107 @@
+package downloader
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// digestAuth answers RFC 2617 digest challenges, reusing the last challenge
+// received so that only the first request of a session needs a round trip.
+type digestAuth struct {
+	username  string
+	password  string
+	challenge map[string]string
+	nc        int
+	access    sync.Mutex
+}
+
+func (d *digestAuth) setChallenge(header string) bool {
+	if !strings.HasPrefix(strings.ToLower(header), "digest ") {
+		return false
+	}
+	defer d.access.Unlock()
+	d.access.Lock()
+	d.challenge = parseChallenge(header[len("digest "):])
+	d.nc = 0
+	return true
+}
+
+func (d *digestAuth) authorization(method, uri string) (header string, ok bool) {
+	defer d.access.Unlock()
+	d.access.Lock()
+	if d.challenge == nil {
+		return
+	}
+	d.nc++
+
+	realm := d.challenge["realm"]
+	nonce := d.challenge["nonce"]
+	ha1 := md5Hex(d.username + ":" + realm + ":" + d.password)
+	ha2 := md5Hex(method + ":" + uri)
+
+	fields := []string{
+		fmt.Sprintf(`username="%s"`, d.username),
+		fmt.Sprintf(`realm="%s"`, realm),
+		fmt.Sprintf(`nonce="%s"`, nonce),
+		fmt.Sprintf(`uri="%s"`, uri),
+	}
+
+	var response string
+	if qop := d.challenge["qop"]; qop != "" {
+		nc := fmt.Sprintf("%08x", d.nc)
+		cnonce := randomHex(8)
+		response = md5Hex(ha1 + ":" + nonce + ":" + nc + ":" + cnonce + ":auth:" + ha2)
+		fields = append(fields, "qop=auth", "nc="+nc, fmt.Sprintf(`cnonce="%s"`, cnonce))
+	} else {
+		response = md5Hex(ha1 + ":" + nonce + ":" + ha2)
+	}
+	fields = append(fields, fmt.Sprintf(`response="%s"`, response), "algorithm=MD5")
+	if opaque, exists := d.challenge["opaque"]; exists {
+		fields = append(fields, fmt.Sprintf(`opaque="%s"`, opaque))
+	}
+	return "Digest " + strings.Join(fields, ", "), true
+}
+
+func parseChallenge(params string) map[string]string {
+	challenge := map[string]string{}
+	for len(params) > 0 {
+		params = strings.TrimLeft(params, " ,")
+		eq := strings.IndexByte(params, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(params[:eq]))
+		params = params[eq+1:]
+
+		var value string
+		if strings.HasPrefix(params, `"`) {
+			end := strings.IndexByte(params[1:], '"')
+			if end < 0 {
+				value, params = params[1:], ""
+			} else {
+				value, params = params[1:end+1], params[end+2:]
+			}
+		} else if comma := strings.IndexByte(params, ','); comma >= 0 {
+			value, params = params[:comma], params[comma+1:]
+		} else {
+			value, params = params, ""
+		}
+		challenge[key] = strings.TrimSpace(value)
+	}
+	return challenge
+}
+
+func md5Hex(value string) string {
+	sum := md5.Sum([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(size int) string {
+	b := make([]byte, size)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
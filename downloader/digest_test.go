@@ -0,0 +1,123 @@
+package downloader
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseChallenge(t *testing.T) {
+	tests := []struct {
+		params string
+		want   map[string]string
+	}{
+		{
+			`realm="testrealm@host.com", qop="auth,auth-int", nonce="dcd98b7102dd2f0e8b11d0f600bfb0c093", opaque="5ccc069c403ebaf9f0171e9517f40e41"`,
+			map[string]string{"realm": "testrealm@host.com", "qop": "auth,auth-int", "nonce": "dcd98b7102dd2f0e8b11d0f600bfb0c093", "opaque": "5ccc069c403ebaf9f0171e9517f40e41"},
+		},
+		{
+			`Realm="seedbox", nonce=abc, algorithm=MD5, stale=FALSE`,
+			map[string]string{"realm": "seedbox", "nonce": "abc", "algorithm": "MD5", "stale": "FALSE"},
+		},
+		{`realm="unterminated`, map[string]string{"realm": "unterminated"}},
+		{"", map[string]string{}},
+	}
+	for _, test := range tests {
+		if challenge := parseChallenge(test.params); !reflect.DeepEqual(challenge, test.want) {
+			t.Errorf("%q = %v, want %v", test.params, challenge, test.want)
+		}
+	}
+}
+
+// authorizationFields parses the fields of an Authorization header.
+func authorizationFields(t *testing.T, header string) map[string]string {
+	if !strings.HasPrefix(header, "Digest ") {
+		t.Fatalf("authorization = %q, want a digest", header)
+	}
+	return parseChallenge(header[len("Digest "):])
+}
+
+func TestDigestAuthorization(t *testing.T) {
+	d := &digestAuth{username: "Mufasa", password: "Circle Of Life"}
+	if _, ok := d.authorization("GET", "/dir/index.html"); ok {
+		t.Error("authorization given before any challenge")
+	}
+	if d.setChallenge(`Basic realm="testrealm@host.com"`) {
+		t.Error("a basic challenge is accepted")
+	}
+
+	// Without quality of protection, as in RFC 2069
+	if !d.setChallenge(`Digest realm="testrealm@host.com", nonce="dcd98b7102dd2f0e8b11d0f600bfb0c093", opaque="5ccc069c403ebaf9f0171e9517f40e41"`) {
+		t.Fatal("digest challenge refused")
+	}
+	ha1 := md5Hex("Mufasa:testrealm@host.com:Circle Of Life")
+	ha2 := md5Hex("GET:/dir/index.html")
+	header, _ := d.authorization("GET", "/dir/index.html")
+	fields := authorizationFields(t, header)
+	if want := md5Hex(ha1 + ":dcd98b7102dd2f0e8b11d0f600bfb0c093:" + ha2); fields["response"] != want {
+		t.Errorf("response = %s, want %s", fields["response"], want)
+	}
+	if fields["opaque"] != "5ccc069c403ebaf9f0171e9517f40e41" || fields["qop"] != "" {
+		t.Errorf("fields = %v", fields)
+	}
+
+	// With quality of protection, the client nonce is chosen by the client
+	d.setChallenge(`Digest realm="testrealm@host.com", qop="auth,auth-int", nonce="dcd98b7102dd2f0e8b11d0f600bfb0c093"`)
+	for _, nc := range []string{"00000001", "00000002"} {
+		header, _ = d.authorization("GET", "/dir/index.html")
+		fields = authorizationFields(t, header)
+		if fields["nc"] != nc || fields["qop"] != "auth" {
+			t.Errorf("fields = %v, want nc %s and qop auth", fields, nc)
+		}
+		want := md5Hex(ha1 + ":dcd98b7102dd2f0e8b11d0f600bfb0c093:" + nc + ":" + fields["cnonce"] + ":auth:" + ha2)
+		if fields["response"] != want {
+			t.Errorf("response = %s, want %s", fields["response"], want)
+		}
+	}
+}
+
+func TestHTTPDigestAuthentication(t *testing.T) {
+	challenges := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if strings.HasPrefix(header, "Digest ") {
+			fields := parseChallenge(header[len("Digest "):])
+			ha1 := md5Hex("user:seedbox:secret")
+			ha2 := md5Hex(r.Method + ":" + r.URL.RequestURI())
+			if fields["response"] == md5Hex(ha1+":n0nce:"+fields["nc"]+":"+fields["cnonce"]+":auth:"+ha2) {
+				_, _ = w.Write([]byte("content"))
+				return
+			}
+		}
+		challenges++
+		w.Header().Set("WWW-Authenticate", `Digest realm="seedbox", qop="auth", nonce="n0nce"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	// Only the first request needs a challenge
+	h := NewHttp(server.URL, "user", "secret", AuthDigest, "/", false, nil)
+	for i := 0; i < 2; i++ {
+		reader, err := h.GetFile("/complete/a b.mkv", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := ioutil.ReadAll(reader)
+		reader.(io.Closer).Close()
+		if string(content) != "content" {
+			t.Errorf("content = %q", content)
+		}
+	}
+	if challenges != 1 {
+		t.Errorf("%d challenges, want 1", challenges)
+	}
+
+	h = NewHttp(server.URL, "user", "wrong", AuthDigest, "/", false, nil)
+	if _, err := h.GetFile("/complete/a.mkv", 0); err == nil {
+		t.Error("wrong password accepted")
+	}
+}
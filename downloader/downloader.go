@@ -3,12 +3,19 @@ package downloader
 import (
 	"io"
 	"seedbox-sync/provider"
+	"time"
 )
 
 type Downloader interface {
-	Connect()
+	Connect() error
 	Disconnect()
 	GetFile(file string, resumeAt uint64) (io.Reader, error)
 	GetRemoteSize(file provider.TorrentFile, remoteCompletePath string) (size int64, err error)
 	GetRoot() string
 }
+
+// ModTimer is implemented by downloaders able to tell when a remote file was
+// last modified.
+type ModTimer interface {
+	GetRemoteModTime(file provider.TorrentFile, remoteCompletePath string) (modTime time.Time, err error)
+}
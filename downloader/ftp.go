@@ -1,8 +1,11 @@
 package downloader
 
 import (
+	"crypto/tls"
+	"fmt"
 	"io"
 	"log"
+	"path"
 	"seedbox-sync/provider"
 	"strconv"
 	"strings"
@@ -12,14 +15,21 @@ import (
 )
 
 type FTP struct {
-	host     string
-	port     int
-	username string
-	password string
-	root     string
-	client   *ftp.ServerConn
+	host      string
+	port      int
+	username  string
+	password  string
+	root      string
+	tlsMode   string
+	tlsConfig *tls.Config
+	client    *ftp.ServerConn
 }
 
+const (
+	TLSExplicit = "explicit"
+	TLSImplicit = "implicit"
+)
+
 func NewFtp(host string, port int, username, password, root string) *FTP {
 	return &FTP{
 		host:     host,
@@ -30,22 +40,46 @@ func NewFtp(host string, port int, username, password, root string) *FTP {
 	}
 }
 
-func (f *FTP) Connect() {
-	c, err := ftp.Dial(f.host+":"+strconv.Itoa(f.port), ftp.DialWithTimeout(5*time.Second))
-	if err != nil {
-		log.Fatal(err)
+func NewFtps(host string, port int, username, password, root string, tlsMode string, tlsConfig *tls.Config) *FTP {
+	if tlsConfig.ServerName == "" {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = host
 	}
-	f.client = c
-	err = c.Login(f.username, f.password)
+	return &FTP{
+		host:      host,
+		port:      port,
+		username:  username,
+		password:  password,
+		root:      root,
+		tlsMode:   tlsMode,
+		tlsConfig: tlsConfig,
+	}
+}
+
+func (f *FTP) Connect() (err error) {
+	options := []ftp.DialOption{ftp.DialWithTimeout(5 * time.Second)}
+	switch f.tlsMode {
+	case TLSExplicit:
+		options = append(options, ftp.DialWithExplicitTLS(f.tlsConfig))
+	case TLSImplicit:
+		options = append(options, ftp.DialWithTLS(f.tlsConfig))
+	}
+	c, err := ftp.Dial(f.host+":"+strconv.Itoa(f.port), options...)
 	if err != nil {
-		log.Fatal(err)
+		return
 	}
+	if err = c.Login(f.username, f.password); err != nil {
+		_ = c.Quit()
+		return
+	}
+	f.client = c
+	return
 }
 
 func (f *FTP) Disconnect() {
 	err := f.client.Quit()
 	if err != nil {
-		log.Fatal(err)
+		log.Println(err)
 	}
 }
 
@@ -59,12 +93,81 @@ func (f *FTP) GetFile(file string, resumeAt uint64) (io.Reader, error) {
 }
 
 func (f *FTP) GetRemoteSize(file provider.TorrentFile, remoteCompletePath string) (size int64, err error) {
-	root := f.GetRoot()
-	remotePath := strings.Replace(remoteCompletePath, root, "", 1)
-	remoteFile := remotePath + "/" + file.Name
+	remoteFile := f.relative(remoteCompletePath) + "/" + file.Name
 	return f.client.FileSize(remoteFile)
 }
 
+func (f *FTP) GetRemoteModTime(file provider.TorrentFile, remoteCompletePath string) (modTime time.Time, err error) {
+	remoteFile := f.relative(remoteCompletePath) + "/" + file.Name
+	if f.client.IsGetTimeSupported() || !f.client.IsTimePreciseInList() {
+		return f.client.GetTime(remoteFile)
+	}
+
+	// Servers without MDTM may still give precise times through MLSD
+	entries, err := f.client.List(path.Dir(remoteFile))
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.Name == path.Base(remoteFile) {
+			return entry.Time, nil
+		}
+	}
+	err = fmt.Errorf("%s not found", remoteFile)
+	return
+}
+
+func (f *FTP) List(path string) (entries []provider.RemoteEntry, err error) {
+	ftpEntries, err := f.client.List(f.relative(path))
+	if err != nil {
+		return
+	}
+	for _, entry := range ftpEntries {
+		if entry.Name == "." || entry.Name == ".." {
+			continue
+		}
+		entries = append(entries, provider.RemoteEntry{
+			Name:    entry.Name,
+			Size:    int64(entry.Size),
+			IsDir:   entry.Type == ftp.EntryTypeFolder,
+			ModTime: entry.Time,
+		})
+	}
+	return
+}
+
+func (f *FTP) Rename(from string, to string) (err error) {
+	return f.client.Rename(f.relative(from), f.relative(to))
+}
+
+func (f *FTP) Mkdir(remotePath string) (err error) {
+	// Parents are created one after the other, a failure being only
+	// reported when the directory is still missing
+	dir := strings.TrimRight(f.relative(remotePath), "/")
+	for i := 1; i <= len(dir); i++ {
+		if i == len(dir) || dir[i] == '/' {
+			err = f.client.MakeDir(dir[:i])
+		}
+	}
+	if err == nil {
+		return
+	}
+	entries, e := f.client.List(path.Dir(dir))
+	if e != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.Name == path.Base(dir) && entry.Type == ftp.EntryTypeFolder {
+			return nil
+		}
+	}
+	return
+}
+
 func (f *FTP) GetRoot() string {
 	return f.root
 }
+
+func (f *FTP) relative(path string) string {
+	return strings.Replace(path, f.root, "", 1)
+}
@@ -0,0 +1,324 @@
+package downloader
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/go-cleanhttp"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"seedbox-sync/provider"
+	"strings"
+	"time"
+)
+
+const (
+	AuthBasic  = "basic"
+	AuthDigest = "digest"
+)
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>` +
+	`<D:propfind xmlns:D="DAV:"><D:prop>` +
+	`<D:resourcetype/><D:getcontentlength/><D:getlastmodified/>` +
+	`</D:prop></D:propfind>`
+
+type HTTP struct {
+	url      string
+	username string
+	password string
+	auth     string
+	root     string
+	webdav   bool
+	httpC    *http.Client
+	digest   *digestAuth
+}
+
+func NewHttp(url, username, password, auth, root string, webdav bool, tlsConfig *tls.Config) *HTTP {
+	httpC := cleanhttp.DefaultPooledClient()
+	if tlsConfig != nil {
+		httpC.Transport.(*http.Transport).TLSClientConfig = tlsConfig
+	}
+	return &HTTP{
+		url:      strings.TrimRight(url, "/"),
+		username: username,
+		password: password,
+		auth:     auth,
+		root:     root,
+		webdav:   webdav,
+		httpC:    httpC,
+		digest:   &digestAuth{username: username, password: password},
+	}
+}
+
+func (h *HTTP) Connect() error {
+	return nil
+}
+
+func (h *HTTP) Disconnect() {
+
+}
+
+func (h *HTTP) GetFile(file string, resumeAt uint64) (io.Reader, error) {
+	resp, err := h.do("GET", file, nil, func(req *http.Request) {
+		if resumeAt > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", resumeAt))
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusOK && resumeAt == 0:
+	case resp.StatusCode == http.StatusPartialContent && resumeAt > 0:
+		// Appending anything else than the requested range would corrupt the file
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", resumeAt)) {
+			resp.Body.Close()
+			return nil, fmt.Errorf("server answered an unexpected range '%s'", resp.Header.Get("Content-Range"))
+		}
+	case resp.StatusCode == http.StatusOK:
+		resp.Body.Close()
+		return nil, errors.New("server ignored the range request, unable to resume")
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("HTTP error %d: %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+
+	return resp.Body, nil
+}
+
+func (h *HTTP) GetRemoteSize(file provider.TorrentFile, remoteCompletePath string) (size int64, err error) {
+	remoteFile := h.relative(remoteCompletePath) + "/" + file.Name
+	if h.webdav {
+		var responses []davResponse
+		if responses, err = h.propfind(remoteFile, "0"); err != nil {
+			return
+		}
+		if len(responses) == 0 {
+			err = errors.New("empty PROPFIND answer")
+			return
+		}
+		return responses[0].Prop.ContentLength, nil
+	}
+
+	resp, err := h.do("HEAD", remoteFile, nil, nil)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("HTTP error %d: %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+		return
+	}
+	if resp.ContentLength < 0 {
+		err = errors.New("server did not send the content length")
+		return
+	}
+	return resp.ContentLength, nil
+}
+
+func (h *HTTP) GetRemoteModTime(file provider.TorrentFile, remoteCompletePath string) (modTime time.Time, err error) {
+	remoteFile := h.relative(remoteCompletePath) + "/" + file.Name
+	var lastModified string
+	if h.webdav {
+		var responses []davResponse
+		if responses, err = h.propfind(remoteFile, "0"); err != nil {
+			return
+		}
+		if len(responses) == 0 {
+			err = errors.New("empty PROPFIND answer")
+			return
+		}
+		lastModified = responses[0].Prop.LastModified
+	} else {
+		var resp *http.Response
+		if resp, err = h.do("HEAD", remoteFile, nil, nil); err != nil {
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("HTTP error %d: %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+			return
+		}
+		lastModified = resp.Header.Get("Last-Modified")
+	}
+	if lastModified == "" {
+		err = errors.New("server did not send the modification time")
+		return
+	}
+	return http.ParseTime(lastModified)
+}
+
+func (h *HTTP) List(remotePath string) (entries []provider.RemoteEntry, err error) {
+	if !h.webdav {
+		err = errors.New("directory listing requires webdav")
+		return
+	}
+	responses, err := h.propfind(h.relative(remotePath), "1")
+	if err != nil {
+		return
+	}
+	base, err := url.Parse(h.url)
+	if err != nil {
+		return
+	}
+	self := strings.TrimRight(base.Path+h.relative(remotePath), "/")
+	for _, response := range responses {
+		href, e := url.Parse(response.Href)
+		if e != nil {
+			continue
+		}
+		name := strings.TrimRight(href.Path, "/")
+		if name == self {
+			continue
+		}
+		modTime, _ := http.ParseTime(response.Prop.LastModified)
+		entries = append(entries, provider.RemoteEntry{
+			Name:    path.Base(name),
+			Size:    response.Prop.ContentLength,
+			IsDir:   response.Prop.ResourceType.Collection != nil,
+			ModTime: modTime,
+		})
+	}
+	return
+}
+
+func (h *HTTP) Rename(from string, to string) (err error) {
+	if !h.webdav {
+		return errors.New("renaming requires webdav")
+	}
+	resp, err := h.do("MOVE", h.relative(from), nil, func(req *http.Request) {
+		req.Header.Set("Destination", h.url+escapePath(h.relative(to)))
+		req.Header.Set("Overwrite", "F")
+	})
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		err = fmt.Errorf("HTTP error %d: %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+	return
+}
+
+func (h *HTTP) Mkdir(remotePath string) (err error) {
+	if !h.webdav {
+		return errors.New("creating directories requires webdav")
+	}
+	// Collections are created one after the other, an existing one being
+	// answered with 405
+	dir := strings.TrimRight(h.relative(remotePath), "/")
+	for i := 1; i <= len(dir); i++ {
+		if i < len(dir) && dir[i] != '/' {
+			continue
+		}
+		var resp *http.Response
+		if resp, err = h.do("MKCOL", dir[:i], nil, nil); err != nil {
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusMethodNotAllowed {
+			err = fmt.Errorf("HTTP error %d: %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+			return
+		}
+	}
+	return
+}
+
+func (h *HTTP) GetRoot() string {
+	return h.root
+}
+
+func (h *HTTP) relative(path string) string {
+	return strings.Replace(path, h.root, "", 1)
+}
+
+type davMultistatus struct {
+	Responses []davResponse `xml:"response"`
+}
+
+type davResponse struct {
+	Href string  `xml:"href"`
+	Prop davProp `xml:"propstat>prop"`
+}
+
+type davProp struct {
+	ContentLength int64  `xml:"getcontentlength"`
+	LastModified  string `xml:"getlastmodified"`
+	ResourceType  struct {
+		Collection *struct{} `xml:"collection"`
+	} `xml:"resourcetype"`
+}
+
+func (h *HTTP) propfind(remotePath string, depth string) (responses []davResponse, err error) {
+	resp, err := h.do("PROPFIND", remotePath, []byte(propfindBody), func(req *http.Request) {
+		req.Header.Set("Depth", depth)
+		req.Header.Set("Content-Type", "application/xml")
+	})
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusMultiStatus {
+		err = fmt.Errorf("HTTP error %d: %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+		return
+	}
+
+	var multistatus davMultistatus
+	if err = xml.NewDecoder(resp.Body).Decode(&multistatus); err != nil {
+		err = fmt.Errorf("can't unmarshall PROPFIND answer body: %v", err)
+		return
+	}
+	return multistatus.Responses, nil
+}
+
+func (h *HTTP) do(method string, remotePath string, body []byte, prepare func(req *http.Request)) (resp *http.Response, err error) {
+	return h.request(method, remotePath, body, prepare, true)
+}
+
+func (h *HTTP) request(method string, remotePath string, body []byte, prepare func(req *http.Request), retry bool) (resp *http.Response, err error) {
+	var req *http.Request
+	if req, err = http.NewRequest(method, h.url+escapePath(remotePath), bytes.NewReader(body)); err != nil {
+		err = fmt.Errorf("can't prepare %s request: %v", method, err)
+		return
+	}
+	if prepare != nil {
+		prepare(req)
+	}
+
+	if h.username != "" {
+		if h.auth == AuthDigest {
+			if header, ok := h.digest.authorization(method, req.URL.RequestURI()); ok {
+				req.Header.Set("Authorization", header)
+			}
+		} else {
+			req.SetBasicAuth(h.username, h.password)
+		}
+	}
+
+	if resp, err = h.httpC.Do(req); err != nil {
+		err = fmt.Errorf("request error: %v", err)
+		return
+	}
+
+	// Is a new digest challenge required ?
+	if resp.StatusCode == http.StatusUnauthorized && h.auth == AuthDigest && retry {
+		if h.digest.setChallenge(resp.Header.Get("WWW-Authenticate")) {
+			resp.Body.Close()
+			return h.request(method, remotePath, body, prepare, false)
+		}
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		err = errors.New("authentication refused: check username and password")
+	}
+	return
+}
+
+func escapePath(remotePath string) string {
+	return (&url.URL{Path: remotePath}).EscapedPath()
+}
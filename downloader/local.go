@@ -0,0 +1,96 @@
+package downloader
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"seedbox-sync/provider"
+	"strings"
+	"time"
+)
+
+type Local struct {
+	mount string
+	root  string
+}
+
+func NewLocal(mount, root string) *Local {
+	return &Local{
+		mount: mount,
+		root:  root,
+	}
+}
+
+func (l *Local) Connect() error {
+	return nil
+}
+
+func (l *Local) Disconnect() {
+
+}
+
+func (l *Local) GetFile(file string, resumeAt uint64) (io.Reader, error) {
+	f, err := os.Open(l.local(file))
+	if err != nil {
+		return nil, err
+	}
+	if _, err = f.Seek(int64(resumeAt), io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (l *Local) GetRemoteSize(file provider.TorrentFile, remoteCompletePath string) (size int64, err error) {
+	fi, err := os.Stat(l.local(l.relative(remoteCompletePath) + "/" + file.Name))
+	if err != nil {
+		return
+	}
+	return fi.Size(), nil
+}
+
+func (l *Local) GetRemoteModTime(file provider.TorrentFile, remoteCompletePath string) (modTime time.Time, err error) {
+	fi, err := os.Stat(l.local(l.relative(remoteCompletePath) + "/" + file.Name))
+	if err != nil {
+		return
+	}
+	return fi.ModTime(), nil
+}
+
+func (l *Local) List(path string) (entries []provider.RemoteEntry, err error) {
+	infos, err := ioutil.ReadDir(l.local(l.relative(path)))
+	if err != nil {
+		return
+	}
+	for _, info := range infos {
+		entries = append(entries, provider.RemoteEntry{
+			Name:    info.Name(),
+			Size:    info.Size(),
+			IsDir:   info.IsDir(),
+			ModTime: info.ModTime(),
+		})
+	}
+	return
+}
+
+func (l *Local) Rename(from string, to string) (err error) {
+	return os.Rename(l.local(l.relative(from)), l.local(l.relative(to)))
+}
+
+func (l *Local) Mkdir(path string) (err error) {
+	return os.MkdirAll(l.local(l.relative(path)), 0755)
+}
+
+func (l *Local) GetRoot() string {
+	return l.root
+}
+
+func (l *Local) relative(path string) string {
+	return strings.Replace(path, l.root, "", 1)
+}
+
+func (l *Local) local(path string) string {
+	return filepath.Join(l.mount, filepath.FromSlash(path))
+}
@@ -0,0 +1,74 @@
+package downloader
+
+import "sync"
+
+type Factory func() (Downloader, error)
+
+// Pool hands out connected downloaders, opening at most size connections at
+// the same time and keeping released ones around for reuse.
+type Pool struct {
+	factory Factory
+	slots   chan struct{}
+	idle    []Downloader
+	access  sync.Mutex
+}
+
+func NewPool(factory Factory, size int) *Pool {
+	if size < 1 {
+		size = 1
+	}
+	return &Pool{
+		factory: factory,
+		slots:   make(chan struct{}, size),
+	}
+}
+
+func (p *Pool) Size() int {
+	return cap(p.slots)
+}
+
+func (p *Pool) Get() (d Downloader, err error) {
+	p.slots <- struct{}{}
+
+	p.access.Lock()
+	if n := len(p.idle); n > 0 {
+		d = p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.access.Unlock()
+		return
+	}
+	p.access.Unlock()
+
+	if d, err = p.factory(); err == nil {
+		err = d.Connect()
+	}
+	if err != nil {
+		d = nil
+		<-p.slots
+	}
+	return
+}
+
+func (p *Pool) Put(d Downloader) {
+	p.access.Lock()
+	p.idle = append(p.idle, d)
+	p.access.Unlock()
+	<-p.slots
+}
+
+// Discard drops a downloader whose connection may be in an unknown state.
+func (p *Pool) Discard(d Downloader) {
+	d.Disconnect()
+	<-p.slots
+}
+
+func (p *Pool) Close() {
+	p.access.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.access.Unlock()
+
+	for _, d := range idle {
+		d.Disconnect()
+	}
+}
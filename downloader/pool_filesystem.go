@@ -0,0 +1,67 @@
+package downloader
+
+import (
+	"errors"
+	"seedbox-sync/provider"
+)
+
+// Every downloader can list, rename and create remote files, plain HTTP ones
+// refusing to do it without webdav.
+var (
+	_ provider.RemoteFileSystem = (*FTP)(nil)
+	_ provider.RemoteFileSystem = (*SFTP)(nil)
+	_ provider.RemoteFileSystem = (*HTTP)(nil)
+	_ provider.RemoteFileSystem = (*Local)(nil)
+)
+
+// PoolFileSystem exposes the remote file system of pooled downloaders,
+// borrowing a connection for each operation.
+type PoolFileSystem struct {
+	pool *Pool
+}
+
+func NewPoolFileSystem(pool *Pool) *PoolFileSystem {
+	return &PoolFileSystem{pool: pool}
+}
+
+func (f *PoolFileSystem) List(path string) (entries []provider.RemoteEntry, err error) {
+	d, err := f.pool.Get()
+	if err != nil {
+		return
+	}
+	defer f.pool.Put(d)
+	fs, ok := d.(provider.RemoteFileSystem)
+	if !ok {
+		err = errors.New("downloader does not support directory listing")
+		return
+	}
+	return fs.List(path)
+}
+
+func (f *PoolFileSystem) Rename(from string, to string) (err error) {
+	d, err := f.pool.Get()
+	if err != nil {
+		return
+	}
+	defer f.pool.Put(d)
+	fs, ok := d.(provider.RemoteFileSystem)
+	if !ok {
+		err = errors.New("downloader does not support renaming")
+		return
+	}
+	return fs.Rename(from, to)
+}
+
+func (f *PoolFileSystem) Mkdir(path string) (err error) {
+	d, err := f.pool.Get()
+	if err != nil {
+		return
+	}
+	defer f.pool.Put(d)
+	fs, ok := d.(provider.RemoteFileSystem)
+	if !ok {
+		err = errors.New("downloader does not support creating directories")
+		return
+	}
+	return fs.Mkdir(path)
+}
@@ -0,0 +1,175 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"seedbox-sync/provider"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+type SFTP struct {
+	host            string
+	port            int
+	username        string
+	auth            []ssh.AuthMethod
+	hostKeyCallback ssh.HostKeyCallback
+	root            string
+	conn            *ssh.Client
+	client          *sftp.Client
+}
+
+func NewSftp(host string, port int, username, root string, auth []ssh.AuthMethod, hostKeyCallback ssh.HostKeyCallback) *SFTP {
+	return &SFTP{
+		host:            host,
+		port:            port,
+		username:        username,
+		auth:            auth,
+		hostKeyCallback: hostKeyCallback,
+		root:            root,
+	}
+}
+
+// SftpAuthMethods builds the SSH authentication methods from a password and/or
+// a private key file, the key being tried first.
+func SftpAuthMethods(password, privateKeyFile, passphrase string) (auth []ssh.AuthMethod, err error) {
+	if privateKeyFile != "" {
+		var key []byte
+		if key, err = ioutil.ReadFile(privateKeyFile); err != nil {
+			err = fmt.Errorf("unable to read private key: %v", err)
+			return
+		}
+		var signer ssh.Signer
+		if passphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(key)
+		}
+		if err != nil {
+			err = fmt.Errorf("unable to parse private key: %v", err)
+			return
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if password != "" {
+		auth = append(auth, ssh.Password(password))
+	}
+	if len(auth) == 0 {
+		err = errors.New("no password nor private key configured")
+	}
+	return
+}
+
+// SftpHostKeyCallback verifies server keys against a known_hosts file unless
+// verification is explicitly disabled.
+func SftpHostKeyCallback(knownHostsFile string, insecureSkipVerify bool) (callback ssh.HostKeyCallback, err error) {
+	if insecureSkipVerify {
+		callback = ssh.InsecureIgnoreHostKey()
+		return
+	}
+	if knownHostsFile == "" {
+		err = errors.New("a known_hosts file is required to verify the server")
+		return
+	}
+	if callback, err = knownhosts.New(knownHostsFile); err != nil {
+		err = fmt.Errorf("unable to load known_hosts: %v", err)
+	}
+	return
+}
+
+func (s *SFTP) Connect() (err error) {
+	conn, err := ssh.Dial("tcp", s.host+":"+strconv.Itoa(s.port), &ssh.ClientConfig{
+		User:            s.username,
+		Auth:            s.auth,
+		HostKeyCallback: s.hostKeyCallback,
+		Timeout:         5 * time.Second,
+	})
+	if err != nil {
+		return
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	s.conn = conn
+	s.client = client
+	return
+}
+
+func (s *SFTP) Disconnect() {
+	err := s.client.Close()
+	if err != nil {
+		log.Println(err)
+	}
+	_ = s.conn.Close()
+}
+
+func (s *SFTP) GetFile(file string, resumeAt uint64) (io.Reader, error) {
+	f, err := s.client.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = f.Seek(int64(resumeAt), io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (s *SFTP) GetRemoteSize(file provider.TorrentFile, remoteCompletePath string) (size int64, err error) {
+	fi, err := s.client.Stat(s.relative(remoteCompletePath) + "/" + file.Name)
+	if err != nil {
+		return
+	}
+	return fi.Size(), nil
+}
+
+func (s *SFTP) GetRemoteModTime(file provider.TorrentFile, remoteCompletePath string) (modTime time.Time, err error) {
+	fi, err := s.client.Stat(s.relative(remoteCompletePath) + "/" + file.Name)
+	if err != nil {
+		return
+	}
+	return fi.ModTime(), nil
+}
+
+func (s *SFTP) List(path string) (entries []provider.RemoteEntry, err error) {
+	infos, err := s.client.ReadDir(s.relative(path))
+	if err != nil {
+		return
+	}
+	for _, info := range infos {
+		entries = append(entries, provider.RemoteEntry{
+			Name:    info.Name(),
+			Size:    info.Size(),
+			IsDir:   info.IsDir(),
+			ModTime: info.ModTime(),
+		})
+	}
+	return
+}
+
+func (s *SFTP) Rename(from string, to string) (err error) {
+	return s.client.Rename(s.relative(from), s.relative(to))
+}
+
+func (s *SFTP) Mkdir(path string) (err error) {
+	return s.client.MkdirAll(s.relative(path))
+}
+
+func (s *SFTP) GetRoot() string {
+	return s.root
+}
+
+func (s *SFTP) relative(path string) string {
+	return strings.Replace(path, s.root, "", 1)
+}
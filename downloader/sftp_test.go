@@ -0,0 +1,279 @@
+package downloader
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"seedbox-sync/provider"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	sftpUser     = "seedbox"
+	sftpPassword = "secret"
+	sftpRoot     = "/remote"
+)
+
+// sftpServer is an in-process SSH server with the sftp subsystem, serving
+// the local file system.
+type sftpServer struct {
+	host    string
+	port    int
+	hostKey ssh.Signer
+}
+
+// newSftpServer accepts the password of the user, or its key when given.
+func newSftpServer(t *testing.T, userKey ssh.PublicKey) *sftpServer {
+	hostKey, err := generateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() == sftpUser && string(password) == sftpPassword {
+				return nil, nil
+			}
+			return nil, os.ErrPermission
+		},
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == sftpUser && userKey != nil && string(key.Marshal()) == string(userKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, os.ErrPermission
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		listener.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSftp(conn, config)
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	return &sftpServer{
+		host:    addr.IP.String(),
+		port:    addr.Port,
+		hostKey: hostKey,
+	}
+}
+
+func serveSftp(conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for request := range requests {
+				// The payload is the length prefixed name of the subsystem
+				ok := request.Type == "subsystem" && string(request.Payload[4:]) == "sftp"
+				request.Reply(ok, nil)
+				if ok {
+					if server, err := sftp.NewServer(channel); err == nil {
+						server.Serve()
+						server.Close()
+					}
+				}
+			}
+		}()
+	}
+}
+
+func generateSigner() (ssh.Signer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.NewSignerFromKey(key)
+}
+
+// knownHosts writes a known_hosts file giving key for the server.
+func (s *sftpServer) knownHosts(t *testing.T, key ssh.PublicKey) string {
+	name := filepath.Join(t.TempDir(), "known_hosts")
+	address := knownhosts.Normalize(net.JoinHostPort(s.host, strconv.Itoa(s.port)))
+	if err := ioutil.WriteFile(name, []byte(knownhosts.Line([]string{address}, key)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func (s *sftpServer) client(t *testing.T, auth []ssh.AuthMethod) *SFTP {
+	callback, err := SftpHostKeyCallback(s.knownHosts(t, s.hostKey.PublicKey()), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewSftp(s.host, s.port, sftpUser, sftpRoot, auth, callback)
+}
+
+func connectSftp(t *testing.T, d *SFTP) {
+	if err := d.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(d.Disconnect)
+}
+
+func TestSftpPasswordAuth(t *testing.T) {
+	server := newSftpServer(t, nil)
+	auth, err := SftpAuthMethods(sftpPassword, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	connectSftp(t, server.client(t, auth))
+
+	auth, err = SftpAuthMethods("wrong", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = server.client(t, auth).Connect(); err == nil {
+		t.Error("a wrong password is accepted")
+	}
+}
+
+// writeKey generates a private key file, returning its public key.
+func writeKey(t *testing.T, name string) ssh.PublicKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(name, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer.PublicKey()
+}
+
+func TestSftpKeyAuth(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "id_ecdsa")
+	otherKeyFile := filepath.Join(dir, "id_other")
+	writeKey(t, otherKeyFile)
+	server := newSftpServer(t, writeKey(t, keyFile))
+
+	auth, err := SftpAuthMethods("", keyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	connectSftp(t, server.client(t, auth))
+
+	auth, err = SftpAuthMethods("", otherKeyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = server.client(t, auth).Connect(); err == nil {
+		t.Error("an unknown key is accepted")
+	}
+}
+
+func TestSftpKnownHostsRejection(t *testing.T) {
+	server := newSftpServer(t, nil)
+	auth, err := SftpAuthMethods(sftpPassword, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := generateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback, err := SftpHostKeyCallback(server.knownHosts(t, other.PublicKey()), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = NewSftp(server.host, server.port, sftpUser, sftpRoot, auth, callback).Connect()
+	if err == nil || !strings.Contains(err.Error(), "key mismatch") {
+		t.Errorf("err = %v, want a known_hosts key mismatch", err)
+	}
+
+	if _, err = SftpHostKeyCallback("", false); err == nil {
+		t.Error("a missing known_hosts file is accepted")
+	}
+}
+
+func TestSftpGetFile(t *testing.T) {
+	server := newSftpServer(t, nil)
+	auth, err := SftpAuthMethods(sftpPassword, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := server.client(t, auth)
+	connectSftp(t, d)
+
+	dir := t.TempDir()
+	content := []byte("0123456789abcdefghij")
+	if err = os.MkdirAll(filepath.Join(dir, "Movie"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "Movie", "a.mkv"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	file := provider.TorrentFile{Name: "Movie/a.mkv"}
+
+	size, err := d.GetRemoteSize(file, sftpRoot+dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len(content)) {
+		t.Errorf("size = %d, want %d", size, len(content))
+	}
+
+	for _, offset := range []uint64{0, 7, uint64(len(content))} {
+		reader, err := d.GetFile(dir+"/"+file.Name, offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(reader)
+		reader.(*sftp.File).Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != string(content[offset:]) {
+			t.Errorf("read %q from %d, want %q", data, offset, content[offset:])
+		}
+	}
+
+	if _, err = d.GetRemoteSize(provider.TorrentFile{Name: "Movie/missing"}, sftpRoot+dir); err == nil {
+		t.Error("the size of a missing file is given")
+	}
+}
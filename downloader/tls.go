@@ -0,0 +1,57 @@
+package downloader
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// NewTLSConfig builds the client TLS configuration shared by the downloaders.
+// When a SHA-256 certificate fingerprint is given, the server leaf certificate
+// must match it, on top of the regular chain verification unless skipped.
+func NewTLSConfig(caFile, serverName, fingerprint string, insecureSkipVerify bool) (config *tls.Config, err error) {
+	config = &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: insecureSkipVerify,
+		ClientSessionCache: tls.NewLRUClientSessionCache(0),
+	}
+
+	if caFile != "" {
+		var pem []byte
+		if pem, err = ioutil.ReadFile(caFile); err != nil {
+			err = fmt.Errorf("unable to read CA bundle: %v", err)
+			return
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			err = errors.New("no certificate found in CA bundle")
+			return
+		}
+		config.RootCAs = pool
+	}
+
+	if fingerprint != "" {
+		var pin []byte
+		if pin, err = hex.DecodeString(strings.Replace(strings.ToLower(fingerprint), ":", "", -1)); err != nil || len(pin) != sha256.Size {
+			err = errors.New("certificate fingerprint must be an hex encoded SHA-256 digest")
+			return
+		}
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("server did not present any certificate")
+			}
+			sum := sha256.Sum256(rawCerts[0])
+			if !bytes.Equal(sum[:], pin) {
+				return fmt.Errorf("server certificate fingerprint %x does not match the pinned one", sum)
+			}
+			return nil
+		}
+	}
+	return
+}
@@ -1,11 +1,32 @@
 module seedbox-sync
 
-go 1.14
+go 1.17
 
 require (
+	github.com/bodgit/sevenzip v1.1.0
 	github.com/cheggaaa/pb/v3 v3.0.4
 	github.com/hashicorp/go-cleanhttp v0.5.1
-	github.com/jlaffaye/ftp v0.0.0-20200331144919-d4caf6ffcab8
-	github.com/mattn/go-isatty v0.0.12 // indirect
+	github.com/jlaffaye/ftp v0.0.0-20220201222555-02685330ee35
+	github.com/nwaples/rardecode v1.1.3
+	github.com/pkg/sftp v1.13.5
 	github.com/robfig/cron/v3 v3.0.1
+	golang.org/x/crypto v0.0.0-20211215153901-e495a2d5b3d3
+)
+
+require (
+	github.com/VividCortex/ewma v1.1.1 // indirect
+	github.com/bodgit/plumbing v1.1.0 // indirect
+	github.com/bodgit/windows v1.0.0 // indirect
+	github.com/connesc/cipherio v0.2.1 // indirect
+	github.com/fatih/color v1.7.0 // indirect
+	github.com/hashicorp/errwrap v1.0.0 // indirect
+	github.com/hashicorp/go-multierror v1.1.0 // indirect
+	github.com/kr/fs v0.1.0 // indirect
+	github.com/mattn/go-colorable v0.1.2 // indirect
+	github.com/mattn/go-isatty v0.0.12 // indirect
+	github.com/mattn/go-runewidth v0.0.7 // indirect
+	github.com/ulikunitz/xz v0.5.7 // indirect
+	go4.org v0.0.0-20200411211856-f5505b9728dd // indirect
+	golang.org/x/sys v0.0.0-20211216021012-1d35b9e2eb4e // indirect
+	golang.org/x/text v0.3.6 // indirect
 )
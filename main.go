@@ -5,25 +5,44 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"seedbox-sync/downloader"
 	"seedbox-sync/model"
 	"seedbox-sync/notifier"
 	"seedbox-sync/provider"
+	"seedbox-sync/state"
 	"seedbox-sync/task"
+	"time"
 )
 
 func main() {
 
 	syncCommand := flag.NewFlagSet("sync", flag.ExitOnError)
 	scheduleCommand := flag.NewFlagSet("schedule", flag.ExitOnError)
+	stateCommand := flag.NewFlagSet("state", flag.ExitOnError)
+	verifyCommand := flag.NewFlagSet("verify", flag.ExitOnError)
+	cleanCommand := flag.NewFlagSet("clean", flag.ExitOnError)
 
 	var config string
+	var hash string
+	var dryRun bool
+	var format string
 	syncCommand.StringVar(&config, "c", "", "")
 	syncCommand.StringVar(&config, "config", "", "")
+	syncCommand.BoolVar(&dryRun, "dry-run", false, "")
+	syncCommand.StringVar(&format, "format", task.PlanText, "")
 	scheduleCommand.StringVar(&config, "c", "", "")
 	scheduleCommand.StringVar(&config, "config", "", "")
+	stateCommand.StringVar(&config, "c", "", "")
+	stateCommand.StringVar(&config, "config", "", "")
+	stateCommand.StringVar(&hash, "hash", "", "")
+	verifyCommand.StringVar(&config, "c", "", "")
+	verifyCommand.StringVar(&config, "config", "", "")
+	verifyCommand.StringVar(&hash, "hash", "", "")
+	cleanCommand.StringVar(&config, "c", "", "")
+	cleanCommand.StringVar(&config, "config", "", "")
 
 	if len(os.Args) < 2 {
 		flag.PrintDefaults()
@@ -36,6 +55,12 @@ func main() {
 		syncCommand.Parse(os.Args[2:])
 	case "schedule":
 		scheduleCommand.Parse(os.Args[2:])
+	case "state":
+		stateCommand.Parse(os.Args[2:])
+	case "verify":
+		verifyCommand.Parse(os.Args[2:])
+	case "clean":
+		cleanCommand.Parse(os.Args[2:])
 	default:
 		flag.PrintDefaults()
 		os.Exit(1)
@@ -51,41 +76,80 @@ func main() {
 	downloaderConfiguration := c.Downloader
 	hooks := c.Hooks
 
-	p, err := retrieveProvider(providerConfiguration)
+	// Without an explicit limit, open as many connections as segments
+	connections := c.Transfer.Connections
+	if connections < 1 {
+		connections = c.Transfer.Segments
+	}
+	pool := downloader.NewPool(func() (downloader.Downloader, error) {
+		return retrieveDownloader(downloaderConfiguration)
+	}, connections)
+
+	// A broken downloader configuration fails now rather than on every file
+	if command != "state" {
+		d, err := pool.Get()
+		if err != nil {
+			fmt.Println("Downloader error:", err)
+			os.Exit(1)
+		}
+		pool.Put(d)
+	}
+
+	p, err := retrieveProvider(providerConfiguration, c.Folders, downloaderConfiguration, pool)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 
-	d, err := retrieveDownloader(downloaderConfiguration)
+	store, err := state.Open(c.State)
 	if err != nil {
-		fmt.Println(err)
+		fmt.Println("State loading error:", err)
 		os.Exit(1)
 	}
 
-	logger := notifier.NewLogger()
+	// Log lines go through the console so that its bars do not overwrite
+	// them. Only the notifiers sharing the terminal wait for each other, hooks
+	// being called by each transfer on its own.
 	console := notifier.NewConsole()
+	output := console.Writer(os.Stdout)
+	logger := notifier.NewLogger(output)
+	terminal := notifier.NewSynchronized(notifier.NewCompose(logger, console))
 	hookNotifier := notifier.NewHookNotifier(hooks)
-	allNotifiers := notifier.NewCompose(logger, console, hookNotifier)
+	allNotifiers := notifier.NewCompose(terminal, hookNotifier)
 
-	t, err := retrieveTask(command, c, p, d, allNotifiers)
+	t, err := retrieveTask(command, c, p, pool, store, hash, dryRun, format, allNotifiers, output)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 
-	d.Connect()
 	t.Execute()
-	d.Disconnect()
 }
 
-func retrieveTask(command string, c model.Configuration, p provider.Provider, d downloader.Downloader, n notifier.Notifier) (t task.Task, err error) {
+func retrieveTask(command string, c model.Configuration, p provider.Provider, pool *downloader.Pool, store *state.Store, hash string, dryRun bool, format string, n notifier.Notifier, output io.Writer) (t task.Task, err error) {
 	switch command {
 	case "sync":
-		t = task.NewSync(c.Folders, p, d, n)
+		if dryRun {
+			if format != task.PlanText && format != task.PlanJSON {
+				err = errors.New("unknown format " + format)
+				return
+			}
+			t = task.NewPlan(c.Folders, c.Transfer, p, pool, store, format)
+			return
+		}
+		t = task.NewSync(c.Folders, c.Transfer, p, pool, store, n, output)
 		return
 	case "schedule":
-		t = task.NewSchedule(c.Scheduler, c.Folders, p, d, n)
+		t = task.NewSchedule(c.Scheduler, c.Folders, c.Transfer, p, pool, store, n, output)
+		return
+	case "state":
+		t = task.NewInspect(store, hash)
+		return
+	case "verify":
+		t = task.NewVerify(c.Folders, c.Transfer, p, pool, store, hash)
+		return
+	case "clean":
+		t = task.NewClean(c.Folders, c.Transfer, p, pool, store, n, output)
 		return
 
 	default:
@@ -97,11 +161,90 @@ func retrieveTask(command string, c model.Configuration, p provider.Provider, d
 func retrieveDownloader(downloaderConfiguration model.DownloaderConfiguration) (d downloader.Downloader, err error) {
 	switch downloaderType := downloaderConfiguration.Type; downloaderType {
 	case "ftp":
-		d = downloader.NewFtp(
+		switch tlsMode := downloaderConfiguration.Tls; tlsMode {
+		case "":
+			d = downloader.NewFtp(
+				downloaderConfiguration.Host,
+				downloaderConfiguration.Port,
+				downloaderConfiguration.Username,
+				downloaderConfiguration.Password,
+				downloaderConfiguration.Root,
+			)
+		case downloader.TLSExplicit, downloader.TLSImplicit:
+			tlsConfig, e := downloader.NewTLSConfig(
+				downloaderConfiguration.CaFile,
+				downloaderConfiguration.ServerName,
+				downloaderConfiguration.Fingerprint,
+				downloaderConfiguration.InsecureSkipVerify,
+			)
+			if e != nil {
+				err = e
+				return
+			}
+			d = downloader.NewFtps(
+				downloaderConfiguration.Host,
+				downloaderConfiguration.Port,
+				downloaderConfiguration.Username,
+				downloaderConfiguration.Password,
+				downloaderConfiguration.Root,
+				tlsMode,
+				tlsConfig,
+			)
+		default:
+			err = errors.New("unknown ftp tls mode")
+		}
+		return
+	case "sftp":
+		auth, e := downloader.SftpAuthMethods(
+			downloaderConfiguration.Password,
+			downloaderConfiguration.PrivateKey,
+			downloaderConfiguration.Passphrase,
+		)
+		if e != nil {
+			err = e
+			return
+		}
+		hostKeyCallback, e := downloader.SftpHostKeyCallback(
+			downloaderConfiguration.KnownHosts,
+			downloaderConfiguration.InsecureSkipVerify,
+		)
+		if e != nil {
+			err = e
+			return
+		}
+		d = downloader.NewSftp(
 			downloaderConfiguration.Host,
 			downloaderConfiguration.Port,
 			downloaderConfiguration.Username,
+			downloaderConfiguration.Root,
+			auth,
+			hostKeyCallback,
+		)
+		return
+	case "http", "webdav":
+		tlsConfig, e := downloader.NewTLSConfig(
+			downloaderConfiguration.CaFile,
+			downloaderConfiguration.ServerName,
+			downloaderConfiguration.Fingerprint,
+			downloaderConfiguration.InsecureSkipVerify,
+		)
+		if e != nil {
+			err = e
+			return
+		}
+		d = downloader.NewHttp(
+			downloaderConfiguration.Url,
+			downloaderConfiguration.Username,
 			downloaderConfiguration.Password,
+			downloaderConfiguration.Auth,
+			downloaderConfiguration.Root,
+			downloaderType == "webdav",
+			tlsConfig,
+		)
+		return
+	case "local":
+		d = downloader.NewLocal(
+			downloaderConfiguration.Mount,
 			downloaderConfiguration.Root,
 		)
 		return
@@ -111,7 +254,7 @@ func retrieveDownloader(downloaderConfiguration model.DownloaderConfiguration) (
 	}
 }
 
-func retrieveProvider(providerConfiguration model.ProviderConfiguration) (p provider.Provider, err error) {
+func retrieveProvider(providerConfiguration model.ProviderConfiguration, folders []model.Folder, downloaderConfiguration model.DownloaderConfiguration, pool *downloader.Pool) (p provider.Provider, err error) {
 	switch providerType := providerConfiguration.Type; providerType {
 	case "transmission":
 		p = provider.NewTransmission(
@@ -120,6 +263,45 @@ func retrieveProvider(providerConfiguration model.ProviderConfiguration) (p prov
 			providerConfiguration.Password,
 		)
 		return
+	case "qbittorrent":
+		p = provider.NewQBittorrent(
+			providerConfiguration.Url,
+			providerConfiguration.Username,
+			providerConfiguration.Password,
+		)
+		return
+	case "deluge":
+		p = provider.NewDeluge(
+			providerConfiguration.Url,
+			providerConfiguration.Password,
+			providerConfiguration.Host,
+		)
+		return
+	case "rtorrent":
+		p = provider.NewRTorrent(
+			providerConfiguration.Url,
+			providerConfiguration.Username,
+			providerConfiguration.Password,
+		)
+		return
+	case "directory":
+		if !listsDirectories(downloaderConfiguration) {
+			err = errors.New("downloader does not support directory listing")
+			return
+		}
+		stablePeriod := provider.DefaultStablePeriod
+		if providerConfiguration.StablePeriod != "" {
+			if stablePeriod, err = time.ParseDuration(providerConfiguration.StablePeriod); err != nil {
+				err = fmt.Errorf("invalid stable period: %v", err)
+				return
+			}
+		}
+		paths := make([]string, len(folders))
+		for i, folder := range folders {
+			paths[i] = folder.RemoteCompletePath
+		}
+		p = provider.NewDirectory(downloader.NewPoolFileSystem(pool), paths, stablePeriod)
+		return
 	default:
 		err = errors.New("unknown provider")
 		return
@@ -136,3 +318,13 @@ func loadConfiguration(file string) (configuration model.Configuration, err erro
 	err = json.Unmarshal(bytes, &configuration)
 	return
 }
+
+// listsDirectories tells whether the downloaders built from a configuration
+// can list and rename remote files, as needed by the directory provider.
+func listsDirectories(downloaderConfiguration model.DownloaderConfiguration) bool {
+	switch downloaderConfiguration.Type {
+	case "ftp", "sftp", "webdav", "local":
+		return true
+	}
+	return false
+}
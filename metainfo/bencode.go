@@ -0,0 +1,96 @@
+package metainfo
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// decodeBencode reads a single bencoded value: integers become int64, byte
+// strings string, lists []interface{} and dictionaries map[string]interface{}.
+func decodeBencode(r *bufio.Reader) (value interface{}, err error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return
+	}
+	switch {
+	case c == 'i':
+		var raw string
+		if raw, err = readUntil(r, 'e'); err != nil {
+			return
+		}
+		if value, err = strconv.ParseInt(raw, 10, 64); err != nil {
+			err = fmt.Errorf("invalid integer '%s'", raw)
+		}
+		return
+	case c == 'l':
+		list := []interface{}{}
+		for {
+			var next byte
+			if next, err = r.ReadByte(); err != nil {
+				return
+			}
+			if next == 'e' {
+				return list, nil
+			}
+			_ = r.UnreadByte()
+			var item interface{}
+			if item, err = decodeBencode(r); err != nil {
+				return
+			}
+			list = append(list, item)
+		}
+	case c == 'd':
+		dict := map[string]interface{}{}
+		for {
+			var next byte
+			if next, err = r.ReadByte(); err != nil {
+				return
+			}
+			if next == 'e' {
+				return dict, nil
+			}
+			_ = r.UnreadByte()
+			var key interface{}
+			if key, err = decodeBencode(r); err != nil {
+				return
+			}
+			k, ok := key.(string)
+			if !ok {
+				err = errors.New("dictionary key is not a string")
+				return
+			}
+			if dict[k], err = decodeBencode(r); err != nil {
+				return
+			}
+		}
+	case c >= '0' && c <= '9':
+		_ = r.UnreadByte()
+		var raw string
+		if raw, err = readUntil(r, ':'); err != nil {
+			return
+		}
+		var length int64
+		if length, err = strconv.ParseInt(raw, 10, 64); err != nil || length < 0 {
+			err = fmt.Errorf("invalid string length '%s'", raw)
+			return
+		}
+		bytes := make([]byte, length)
+		if _, err = io.ReadFull(r, bytes); err != nil {
+			return
+		}
+		return string(bytes), nil
+	}
+	err = fmt.Errorf("unexpected character '%c'", c)
+	return
+}
+
+func readUntil(r *bufio.Reader, delimiter byte) (string, error) {
+	raw, err := r.ReadString(delimiter)
+	if err != nil {
+		return "", err
+	}
+	return raw[:len(raw)-1], nil
+}
@@ -0,0 +1,100 @@
+package metainfo
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+const pieceHashSize = 20
+
+// ErrNoPieceHashes is returned for version 2 only torrents, whose files are
+// hashed with merkle trees.
+var ErrNoPieceHashes = errors.New("unsupported metainfo: no v1 piece hashes")
+
+// Metainfo holds what is needed from a .torrent file to check its content.
+type Metainfo struct {
+	Name        string
+	PieceLength int64
+	Pieces      [][]byte
+	Files       []File
+}
+
+// File names include the torrent name for multi-file torrents, as the
+// providers do. Padding files are not stored on disk and only hold zeros.
+type File struct {
+	Name    string
+	Length  int64
+	Padding bool
+}
+
+func Parse(data []byte) (m Metainfo, err error) {
+	value, err := decodeBencode(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		err = fmt.Errorf("invalid metainfo: %v", err)
+		return
+	}
+	root, ok := value.(map[string]interface{})
+	if !ok {
+		err = errors.New("invalid metainfo: not a dictionary")
+		return
+	}
+	info, ok := root["info"].(map[string]interface{})
+	if !ok {
+		err = errors.New("invalid metainfo: missing info dictionary")
+		return
+	}
+
+	m.Name, _ = info["name"].(string)
+	m.PieceLength, _ = info["piece length"].(int64)
+	pieces, _ := info["pieces"].(string)
+	if m.PieceLength <= 0 || pieces == "" {
+		err = ErrNoPieceHashes
+		return
+	}
+	if len(pieces)%pieceHashSize != 0 {
+		err = errors.New("invalid metainfo: truncated piece hashes")
+		return
+	}
+	for i := 0; i < len(pieces); i += pieceHashSize {
+		m.Pieces = append(m.Pieces, []byte(pieces[i:i+pieceHashSize]))
+	}
+
+	if length, ok := info["length"].(int64); ok {
+		m.Files = []File{{Name: m.Name, Length: length}}
+		return
+	}
+	files, ok := info["files"].([]interface{})
+	if !ok {
+		err = errors.New("invalid metainfo: neither length nor files")
+		return
+	}
+	for _, f := range files {
+		file, _ := f.(map[string]interface{})
+		length, _ := file["length"].(int64)
+		elements, _ := file["path"].([]interface{})
+		parts := []string{m.Name}
+		for _, element := range elements {
+			part, _ := element.(string)
+			parts = append(parts, part)
+		}
+		attr, _ := file["attr"].(string)
+		m.Files = append(m.Files, File{
+			Name:    path.Join(parts...),
+			Length:  length,
+			Padding: strings.Contains(attr, "p"),
+		})
+	}
+	return
+}
+
+// Length returns the total length of the torrent content.
+func (m Metainfo) Length() (length int64) {
+	for _, file := range m.Files {
+		length += file.Length
+	}
+	return
+}
@@ -0,0 +1,96 @@
+package metainfo
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSingleFile(t *testing.T) {
+	hashes := strings.Repeat("a", 20) + strings.Repeat("b", 20)
+	m, err := Parse([]byte("d8:announce15:http://tracker/4:infod6:lengthi20000e4:name5:a.mkv12:piece lengthi16384e6:pieces40:" + hashes + "ee"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Metainfo{
+		Name:        "a.mkv",
+		PieceLength: 16384,
+		Pieces:      [][]byte{[]byte(hashes[:20]), []byte(hashes[20:])},
+		Files:       []File{{Name: "a.mkv", Length: 20000}},
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("metainfo = %+v, want %+v", m, want)
+	}
+	if m.Length() != 20000 {
+		t.Errorf("length = %d, want 20000", m.Length())
+	}
+}
+
+func TestParseMultiFile(t *testing.T) {
+	m, err := Parse([]byte("d4:infod5:filesl" +
+		"d6:lengthi100e4:pathl3:sub5:a.mkvee" +
+		"d4:attr1:p6:lengthi28e4:pathl4:.pad2:28ee" +
+		"d6:lengthi5e4:pathl5:b.nfoee" +
+		"e4:name5:Movie12:piece lengthi64e6:pieces40:" + strings.Repeat("x", 40) + "ee"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []File{
+		{Name: "Movie/sub/a.mkv", Length: 100},
+		{Name: "Movie/.pad/28", Length: 28, Padding: true},
+		{Name: "Movie/b.nfo", Length: 5},
+	}
+	if !reflect.DeepEqual(m.Files, want) {
+		t.Errorf("files = %+v, want %+v", m.Files, want)
+	}
+	if m.Length() != 133 {
+		t.Errorf("length = %d, want 133", m.Length())
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	hashes := strings.Repeat("a", 20)
+	for _, data := range []string{
+		"",
+		"i42e",
+		"d4:infoi1ee",
+		"d4:infod6:lengthi1e4:name1:aee",
+		"d4:infod6:lengthi1e4:name1:a12:piece lengthi16e6:pieces19:" + hashes[:19] + "ee",
+		"d4:infod4:name1:a12:piece lengthi16e6:pieces20:" + hashes + "ee",
+		"d4:infod6:lengthi1e4:name1:a12:piece lengthi16e6:pieces20:" + hashes,
+	} {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Errorf("%q is accepted", data)
+		}
+	}
+}
+
+func TestDecodeBencode(t *testing.T) {
+	tests := []struct {
+		data string
+		want interface{}
+	}{
+		{"i-42e", int64(-42)},
+		{"0:", ""},
+		{"4:spam", "spam"},
+		{"l4:spami1ee", []interface{}{"spam", int64(1)}},
+		{"d3:cow3:moo4:listlee", map[string]interface{}{"cow": "moo", "list": []interface{}{}}},
+	}
+	for _, test := range tests {
+		value, err := decodeBencode(bufio.NewReader(strings.NewReader(test.data)))
+		if err != nil {
+			t.Errorf("%q: %v", test.data, err)
+			continue
+		}
+		if !reflect.DeepEqual(value, test.want) {
+			t.Errorf("%q = %#v, want %#v", test.data, value, test.want)
+		}
+	}
+
+	for _, data := range []string{"i4x2e", "5:abc", "-1:a", "di1e1:ae", "x", "l1:a"} {
+		if _, err := decodeBencode(bufio.NewReader(strings.NewReader(data))); err == nil {
+			t.Errorf("%q is accepted", data)
+		}
+	}
+}
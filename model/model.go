@@ -6,29 +6,100 @@ type Configuration struct {
 	Folders    []Folder                `json:"folders"`
 	Hooks      []Hook                  `json:"hooks"`
 	Scheduler  SchedulerConfiguration  `json:"scheduler"`
+	Transfer   TransferConfiguration   `json:"transfer"`
+	State      string                  `json:"state"`
 }
 
 type ProviderConfiguration struct {
-	Type     string `json:"type"`
-	Url      string `json:"url"`
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Type         string `json:"type"`
+	Url          string `json:"url"`
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	Host         string `json:"host"`
+	StablePeriod string `json:"stablePeriod"`
 }
 
 type DownloaderConfiguration struct {
-	Type     string `json:"type"`
-	Host     string `json:"host"`
-	Port     int    `json:"port"`
-	Username string `json:"username"`
-	Password string `json:"password"`
-	Root     string `json:"root"`
+	Type               string `json:"type"`
+	Host               string `json:"host"`
+	Port               int    `json:"port"`
+	Username           string `json:"username"`
+	Password           string `json:"password"`
+	Root               string `json:"root"`
+	Mount              string `json:"mount"`
+	Url                string `json:"url"`
+	Auth               string `json:"auth"`
+	PrivateKey         string `json:"privateKey"`
+	Passphrase         string `json:"passphrase"`
+	KnownHosts         string `json:"knownHosts"`
+	Tls                string `json:"tls"`
+	CaFile             string `json:"caFile"`
+	ServerName         string `json:"serverName"`
+	Fingerprint        string `json:"fingerprint"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify"`
 }
 
 type Folder struct {
-	RemoteCompletePath      string `json:"remoteCompletePath"`
-	RemoteSharePath         string `json:"remoteSharePath"`
-	LocalTempPath           string `json:"localTempPath"`
-	LocalPostProcessingPath string `json:"localPostProcessingPath"`
+	RemoteCompletePath      string        `json:"remoteCompletePath"`
+	RemoteSharePath         string        `json:"remoteSharePath"`
+	LocalTempPath           string        `json:"localTempPath"`
+	LocalPostProcessingPath string        `json:"localPostProcessingPath"`
+	Include                 []TorrentRule `json:"include"`
+	Exclude                 []TorrentRule `json:"exclude"`
+	Files                   FileRules     `json:"files"`
+	SyncFilesEarly          bool          `json:"syncFilesEarly"`
+	Verify                  bool          `json:"verify"`
+	Quota                   int64         `json:"quota"`
+	Conflict                string        `json:"conflict"`
+	TransferMode            string        `json:"transferMode"`
+	Permissions             Permissions   `json:"permissions"`
+	Extract                 Extract       `json:"extract"`
+	Clean                   bool          `json:"clean"`
+}
+
+// Extract unpacks the rar, zip and 7z archives of the torrents next to them
+// in the post processing path once they are synchronised.
+type Extract struct {
+	Enabled        bool `json:"enabled"`
+	DeleteArchives bool `json:"deleteArchives"`
+}
+
+// Permissions of the synchronised files and directories, modes being octal
+// strings. Without any mode nor umask they get the ones of the process umask.
+// Ownership is only changed for the ids given.
+type Permissions struct {
+	FileMode string `json:"fileMode"`
+	DirMode  string `json:"dirMode"`
+	Umask    string `json:"umask"`
+	Uid      *int   `json:"uid"`
+	Gid      *int   `json:"gid"`
+}
+
+// FileRules select the files of a torrent to synchronise. Globs are matched
+// case-insensitively against both the path of a file within the torrent and
+// its base name.
+// Excluded files are left on the remote ("keep", the default) or also removed
+// from the local temp path once the torrent is synchronised ("delete").
+type FileRules struct {
+	Include           []string `json:"include"`
+	Exclude           []string `json:"exclude"`
+	ExcludeExtensions []string `json:"excludeExtensions"`
+	MinSize           int64    `json:"minSize"`
+	Excluded          string   `json:"excluded"`
+}
+
+// TorrentRule matches a torrent when all of its criteria match. Durations use
+// the Go syntax ("72h") and dates either "2006-01-02" or RFC 3339.
+type TorrentRule struct {
+	Name           string   `json:"name"`
+	Labels         []string `json:"labels"`
+	Trackers       []string `json:"trackers"`
+	MinSize        int64    `json:"minSize"`
+	MaxSize        int64    `json:"maxSize"`
+	MinSeedingTime string   `json:"minSeedingTime"`
+	MinRatio       float64  `json:"minRatio"`
+	AddedBefore    string   `json:"addedBefore"`
+	AddedAfter     string   `json:"addedAfter"`
 }
 
 type Hook struct {
@@ -40,3 +111,14 @@ type Hook struct {
 type SchedulerConfiguration struct {
 	Cron string `json:"cron"`
 }
+
+type TransferConfiguration struct {
+	Connections      int    `json:"connections"`
+	Torrents         int    `json:"torrents"`
+	Files            int    `json:"files"`
+	Segments         int    `json:"segments"`
+	SegmentThreshold int64  `json:"segmentThreshold"`
+	MinFreeSpace     int64  `json:"minFreeSpace"`
+	PartSuffix       string `json:"partSuffix"`
+	PartDir          string `json:"partDir"`
+}
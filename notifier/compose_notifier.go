@@ -49,6 +49,12 @@ func (n *ComposeNotifier) EndTorrent(torrent provider.Torrent) {
 	}
 }
 
+func (n *ComposeNotifier) SkipTorrent(torrent provider.Torrent, reason string) {
+	for _, notifier := range n.notifiers {
+		notifier.SkipTorrent(torrent, reason)
+	}
+}
+
 func (n *ComposeNotifier) StartFile(file provider.TorrentFile) {
 	for _, notifier := range n.notifiers {
 		notifier.StartFile(file)
@@ -66,3 +72,27 @@ func (n *ComposeNotifier) EndFile(file provider.TorrentFile, success bool) {
 		notifier.EndFile(file, success)
 	}
 }
+
+func (n *ComposeNotifier) Conflict(file provider.TorrentFile, destination string, decision string) {
+	for _, notifier := range n.notifiers {
+		notifier.Conflict(file, destination, decision)
+	}
+}
+
+func (n *ComposeNotifier) Reclaimed(folder model.Folder, files int, bytes int64) {
+	for _, notifier := range n.notifiers {
+		notifier.Reclaimed(folder, files, bytes)
+	}
+}
+
+func (n *ComposeNotifier) Extracted(torrent provider.Torrent, archive string, files int, success bool) {
+	for _, notifier := range n.notifiers {
+		notifier.Extracted(torrent, archive, files, success)
+	}
+}
+
+func (n *ComposeNotifier) Unverified(torrent provider.Torrent, reason string) {
+	for _, notifier := range n.notifiers {
+		notifier.Unverified(torrent, reason)
+	}
+}
@@ -1,27 +1,71 @@
 package notifier
 
 import (
+	"fmt"
 	"github.com/cheggaaa/pb/v3"
+	"io"
+	"os"
 	"seedbox-sync/model"
 	"seedbox-sync/provider"
+	"sync"
+	"time"
 )
 
+const consoleRefreshRate = 200 * time.Millisecond
+
+// ConsoleNotifier renders one progress bar per file being transferred. Bars
+// are drawn together so that simultaneous transfers do not overwrite each
+// other, finished ones being printed once above the active ones.
 type ConsoleNotifier struct {
-	bars map[string]*pb.ProgressBar
+	bars     map[string]*pb.ProgressBar
+	active   []string
+	finished []*pb.ProgressBar
+	lines    int
+	output   io.Writer
+	stop     chan struct{}
+	done     chan struct{}
+	access   sync.Mutex
 }
 
 func NewConsole() *ConsoleNotifier {
 	return &ConsoleNotifier{
-		bars: map[string]*pb.ProgressBar{},
+		bars:   map[string]*pb.ProgressBar{},
+		output: os.Stderr,
 	}
 }
 
-func (n *ConsoleNotifier) StartSynchro() {
+// Writer returns a writer to output for the other notifiers sharing the
+// terminal: the bars are cleared before writing above them, and drawn again at
+// the next refresh.
+func (n *ConsoleNotifier) Writer(output io.Writer) io.Writer {
+	return &consoleWriter{console: n, output: output}
+}
 
+type consoleWriter struct {
+	console *ConsoleNotifier
+	output  io.Writer
 }
 
-func (n *ConsoleNotifier) EndSynchro() {
+func (w *consoleWriter) Write(p []byte) (int, error) {
+	defer w.console.access.Unlock()
+	w.console.access.Lock()
+	w.console.clear()
+	return w.output.Write(p)
+}
 
+func (n *ConsoleNotifier) StartSynchro() {
+	n.stop = make(chan struct{})
+	n.done = make(chan struct{})
+	go n.refresh(n.stop, n.done)
+}
+
+func (n *ConsoleNotifier) EndSynchro() {
+	if n.stop == nil {
+		return
+	}
+	close(n.stop)
+	<-n.done
+	n.stop = nil
 }
 
 func (n *ConsoleNotifier) StartFolder(folder model.Folder) {
@@ -40,14 +84,26 @@ func (n *ConsoleNotifier) EndTorrent(torrent provider.Torrent) {
 
 }
 
+func (n *ConsoleNotifier) SkipTorrent(torrent provider.Torrent, reason string) {
+
+}
+
 func (n *ConsoleNotifier) StartFile(file provider.TorrentFile) {
-	bar := pb.Full.Start64(file.Length)
+	defer n.access.Unlock()
+	n.access.Lock()
+	bar := pb.New64(file.Length).SetTemplate(pb.Full)
 	bar.Set(pb.Bytes, true)
+	bar.Set(pb.Static, true)
 	bar.Start()
+	if _, exists := n.bars[file.Name]; !exists {
+		n.active = append(n.active, file.Name)
+	}
 	n.bars[file.Name] = bar
 }
 
 func (n *ConsoleNotifier) ProgressFile(file provider.TorrentFile, bytesRead int64, totalBytesRead int64) {
+	defer n.access.Unlock()
+	n.access.Lock()
 	bar := n.bars[file.Name]
 	if bar != nil {
 		bar.SetCurrent(bytesRead)
@@ -55,9 +111,83 @@ func (n *ConsoleNotifier) ProgressFile(file provider.TorrentFile, bytesRead int6
 }
 
 func (n *ConsoleNotifier) EndFile(file provider.TorrentFile, success bool) {
+	defer n.access.Unlock()
+	n.access.Lock()
 	bar := n.bars[file.Name]
-	bar.SetCurrent(file.Length)
-	if bar != nil {
-		bar.Finish()
+	if bar == nil {
+		return
+	}
+	if success {
+		bar.SetCurrent(file.Length)
+	}
+	bar.Finish()
+	for i, name := range n.active {
+		if name == file.Name {
+			n.active = append(n.active[:i], n.active[i+1:]...)
+			break
+		}
+	}
+	delete(n.bars, file.Name)
+	n.finished = append(n.finished, bar)
+}
+
+func (n *ConsoleNotifier) Conflict(file provider.TorrentFile, destination string, decision string) {
+
+}
+
+func (n *ConsoleNotifier) Reclaimed(folder model.Folder, files int, bytes int64) {
+
+}
+
+func (n *ConsoleNotifier) Extracted(torrent provider.Torrent, archive string, files int, success bool) {
+
+}
+
+func (n *ConsoleNotifier) Unverified(torrent provider.Torrent, reason string) {
+
+}
+
+func (n *ConsoleNotifier) refresh(stop chan struct{}, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(consoleRefreshRate)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			n.render()
+		case <-stop:
+			n.render()
+			return
+		}
+	}
+}
+
+// clear erases the active bars, the cursor being left where they started.
+func (n *ConsoleNotifier) clear() {
+	if n.lines > 0 {
+		fmt.Fprintf(n.output, "\033[%dA\r\033[J", n.lines)
+		n.lines = 0
+	}
+}
+
+func (n *ConsoleNotifier) render() {
+	defer n.access.Unlock()
+	n.access.Lock()
+
+	var out string
+	if n.lines > 0 {
+		out = fmt.Sprintf("\033[%dA", n.lines)
+	}
+	// Finished bars are printed a last time and scroll away with the output
+	for _, bar := range n.finished {
+		out += "\r" + bar.String() + "\033[K\n"
+	}
+	n.finished = nil
+	for _, name := range n.active {
+		out += "\r" + n.bars[name].String() + "\033[K\n"
+	}
+	n.lines = len(n.active)
+	if out != "" {
+		fmt.Fprint(n.output, out)
 	}
 }
@@ -47,6 +47,10 @@ func (n *HookNotifier) EndTorrent(torrent provider.Torrent) {
 
 }
 
+func (n *HookNotifier) SkipTorrent(torrent provider.Torrent, reason string) {
+	n.call("download/skip")
+}
+
 func (n *HookNotifier) StartFile(file provider.TorrentFile) {
 
 }
@@ -59,6 +63,22 @@ func (n *HookNotifier) EndFile(file provider.TorrentFile, success bool) {
 
 }
 
+func (n *HookNotifier) Conflict(file provider.TorrentFile, destination string, decision string) {
+	n.call("move/conflict")
+}
+
+func (n *HookNotifier) Reclaimed(folder model.Folder, files int, bytes int64) {
+	n.call("clean/post")
+}
+
+func (n *HookNotifier) Extracted(torrent provider.Torrent, archive string, files int, success bool) {
+	n.call("extract/post")
+}
+
+func (n *HookNotifier) Unverified(torrent provider.Torrent, reason string) {
+	n.call("verify/skip")
+}
+
 func (n *HookNotifier) call(event string) {
 	for _, hook := range n.hooks {
 		if hook.Event == event {
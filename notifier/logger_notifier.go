@@ -2,27 +2,29 @@ package notifier
 
 import (
 	"fmt"
+	"io"
 	"seedbox-sync/model"
 	"seedbox-sync/provider"
 )
 
 type LoggerNotifier struct {
+	output io.Writer
 }
 
-func NewLogger() *LoggerNotifier {
-	return &LoggerNotifier{}
+func NewLogger(output io.Writer) *LoggerNotifier {
+	return &LoggerNotifier{output: output}
 }
 
 func (n *LoggerNotifier) StartSynchro() {
-	fmt.Println("Start synchronisation...")
+	fmt.Fprintln(n.output, "Start synchronisation...")
 }
 
 func (n *LoggerNotifier) EndSynchro() {
-	fmt.Println("Synchronisation ended")
+	fmt.Fprintln(n.output, "Synchronisation ended")
 }
 
 func (n *LoggerNotifier) StartFolder(folder model.Folder) {
-	fmt.Println("Start download folder ", folder.RemoteCompletePath)
+	fmt.Fprintln(n.output, "Start download folder ", folder.RemoteCompletePath)
 }
 
 func (n *LoggerNotifier) EndFolder(folder model.Folder) {
@@ -30,15 +32,19 @@ func (n *LoggerNotifier) EndFolder(folder model.Folder) {
 }
 
 func (n *LoggerNotifier) StartTorrent(torrent provider.Torrent) {
-	fmt.Println(torrent.Name)
+	fmt.Fprintln(n.output, torrent.Name)
 }
 
 func (n *LoggerNotifier) EndTorrent(torrent provider.Torrent) {
 
 }
 
+func (n *LoggerNotifier) SkipTorrent(torrent provider.Torrent, reason string) {
+	fmt.Fprintln(n.output, "Skip", torrent.Name, ":", reason)
+}
+
 func (n *LoggerNotifier) StartFile(file provider.TorrentFile) {
-	fmt.Println(file.Name)
+	fmt.Fprintln(n.output, file.Name)
 }
 
 func (n *LoggerNotifier) ProgressFile(file provider.TorrentFile, bytesRead int64, totalBytesRead int64) {
@@ -48,3 +54,25 @@ func (n *LoggerNotifier) ProgressFile(file provider.TorrentFile, bytesRead int64
 func (n *LoggerNotifier) EndFile(file provider.TorrentFile, success bool) {
 
 }
+
+func (n *LoggerNotifier) Conflict(file provider.TorrentFile, destination string, decision string) {
+	fmt.Fprintln(n.output, "Conflict", destination, ":", decision)
+}
+
+func (n *LoggerNotifier) Reclaimed(folder model.Folder, files int, bytes int64) {
+	if files > 0 {
+		fmt.Fprintln(n.output, "Cleaned", folder.LocalTempPath, ":", files, "files,", bytes, "bytes reclaimed")
+	}
+}
+
+func (n *LoggerNotifier) Extracted(torrent provider.Torrent, archive string, files int, success bool) {
+	if success {
+		fmt.Fprintln(n.output, "Extracted", archive, ":", files, "files")
+	} else {
+		fmt.Fprintln(n.output, "Extraction failed", archive)
+	}
+}
+
+func (n *LoggerNotifier) Unverified(torrent provider.Torrent, reason string) {
+	fmt.Fprintln(n.output, "Not verified", torrent.Name, ":", reason)
+}
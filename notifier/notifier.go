@@ -12,7 +12,12 @@ type Notifier interface {
 	EndFolder(folder model.Folder)
 	StartTorrent(torrent provider.Torrent)
 	EndTorrent(torrent provider.Torrent)
+	SkipTorrent(torrent provider.Torrent, reason string)
 	StartFile(file provider.TorrentFile)
 	ProgressFile(file provider.TorrentFile, bytesRead int64, totalBytesRead int64)
 	EndFile(file provider.TorrentFile, success bool)
+	Conflict(file provider.TorrentFile, destination string, decision string)
+	Reclaimed(folder model.Folder, files int, bytes int64)
+	Extracted(torrent provider.Torrent, archive string, files int, success bool)
+	Unverified(torrent provider.Torrent, reason string)
 }
@@ -0,0 +1,102 @@
+package notifier
+
+import (
+	"seedbox-sync/model"
+	"seedbox-sync/provider"
+	"sync"
+)
+
+// SynchronizedNotifier serializes the calls made to a notifier so that
+// concurrent transfers can share notifiers which are not safe for it.
+type SynchronizedNotifier struct {
+	notifier Notifier
+	access   sync.Mutex
+}
+
+func NewSynchronized(notifier Notifier) *SynchronizedNotifier {
+	return &SynchronizedNotifier{notifier: notifier}
+}
+
+func (n *SynchronizedNotifier) StartSynchro() {
+	defer n.access.Unlock()
+	n.access.Lock()
+	n.notifier.StartSynchro()
+}
+
+func (n *SynchronizedNotifier) EndSynchro() {
+	defer n.access.Unlock()
+	n.access.Lock()
+	n.notifier.EndSynchro()
+}
+
+func (n *SynchronizedNotifier) StartFolder(folder model.Folder) {
+	defer n.access.Unlock()
+	n.access.Lock()
+	n.notifier.StartFolder(folder)
+}
+
+func (n *SynchronizedNotifier) EndFolder(folder model.Folder) {
+	defer n.access.Unlock()
+	n.access.Lock()
+	n.notifier.EndFolder(folder)
+}
+
+func (n *SynchronizedNotifier) StartTorrent(torrent provider.Torrent) {
+	defer n.access.Unlock()
+	n.access.Lock()
+	n.notifier.StartTorrent(torrent)
+}
+
+func (n *SynchronizedNotifier) EndTorrent(torrent provider.Torrent) {
+	defer n.access.Unlock()
+	n.access.Lock()
+	n.notifier.EndTorrent(torrent)
+}
+
+func (n *SynchronizedNotifier) SkipTorrent(torrent provider.Torrent, reason string) {
+	defer n.access.Unlock()
+	n.access.Lock()
+	n.notifier.SkipTorrent(torrent, reason)
+}
+
+func (n *SynchronizedNotifier) StartFile(file provider.TorrentFile) {
+	defer n.access.Unlock()
+	n.access.Lock()
+	n.notifier.StartFile(file)
+}
+
+func (n *SynchronizedNotifier) ProgressFile(file provider.TorrentFile, bytesRead int64, totalBytesRead int64) {
+	defer n.access.Unlock()
+	n.access.Lock()
+	n.notifier.ProgressFile(file, bytesRead, totalBytesRead)
+}
+
+func (n *SynchronizedNotifier) EndFile(file provider.TorrentFile, success bool) {
+	defer n.access.Unlock()
+	n.access.Lock()
+	n.notifier.EndFile(file, success)
+}
+
+func (n *SynchronizedNotifier) Conflict(file provider.TorrentFile, destination string, decision string) {
+	defer n.access.Unlock()
+	n.access.Lock()
+	n.notifier.Conflict(file, destination, decision)
+}
+
+func (n *SynchronizedNotifier) Reclaimed(folder model.Folder, files int, bytes int64) {
+	defer n.access.Unlock()
+	n.access.Lock()
+	n.notifier.Reclaimed(folder, files, bytes)
+}
+
+func (n *SynchronizedNotifier) Extracted(torrent provider.Torrent, archive string, files int, success bool) {
+	defer n.access.Unlock()
+	n.access.Lock()
+	n.notifier.Extracted(torrent, archive, files, success)
+}
+
+func (n *SynchronizedNotifier) Unverified(torrent provider.Torrent, reason string) {
+	defer n.access.Unlock()
+	n.access.Lock()
+	n.notifier.Unverified(torrent, reason)
+}
@@ -0,0 +1,307 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/go-cleanhttp"
+	"net/http"
+	"net/http/cookiejar"
+	"strings"
+	"sync"
+	"time"
+)
+
+const delugeNotAuthenticated = 1
+
+// Deluge file priorities go from 0 (skipped) to 7 (high), 4 being normal.
+const (
+	delugeNormalPriority = 4
+	delugeHighPriority   = 7
+)
+
+type Deluge struct {
+	url         string
+	password    string
+	host        string
+	httpC       *http.Client
+	requestID   int
+	requestLock sync.Mutex
+}
+
+func NewDeluge(url, password, host string) *Deluge {
+	httpC := cleanhttp.DefaultPooledClient()
+	httpC.Jar, _ = cookiejar.New(nil)
+	return &Deluge{
+		url:      url,
+		password: password,
+		host:     host,
+		httpC:    httpC,
+	}
+}
+
+func (d *Deluge) GetTorrents() (torrents []Torrent, err error) {
+	if err = d.ensureConnected(); err != nil {
+		return
+	}
+
+	var result map[string]delugeTorrent
+	if err = d.rpcCall("core.get_torrents_status", []interface{}{
+		map[string]interface{}{},
+		[]string{
+			"name",
+			"save_path",
+			"progress",
+			"files",
+			"file_progress",
+			"file_priorities",
+			"label",
+			"tracker_host",
+			"total_size",
+			"seeding_time",
+			"ratio",
+			"time_added",
+		},
+	}, &result); err != nil {
+		err = fmt.Errorf("'core.get_torrents_status' rpc method failed: %v", err)
+		return
+	}
+
+	torrents = make([]Torrent, 0, len(result))
+	for hash, torrent := range result {
+		var files = make([]TorrentFile, len(torrent.Files))
+		for j, file := range torrent.Files {
+			var progress float64
+			if j < len(torrent.FileProgress) {
+				progress = torrent.FileProgress[j]
+			}
+			priority := delugeNormalPriority
+			if j < len(torrent.FilePriorities) {
+				priority = torrent.FilePriorities[j]
+			}
+			files[j] = TorrentFile{
+				Name:           file.Path,
+				Length:         file.Size,
+				BytesCompleted: completedBytes(file.Size, progress),
+				Wanted:         priority != 0,
+				Priority:       delugePriority(priority),
+			}
+		}
+
+		// The label only exists with the label plugin enabled
+		var labels []string
+		if torrent.Label != "" {
+			labels = []string{torrent.Label}
+		}
+		var trackers []string
+		if torrent.TrackerHost != "" {
+			trackers = []string{torrent.TrackerHost}
+		}
+
+		torrents = append(torrents, Torrent{
+			Hash:        hash,
+			Name:        torrent.Name,
+			PercentDone: torrent.Progress / 100,
+			Files:       files,
+			DownloadDir: strings.TrimRight(torrent.SavePath, "/"),
+			Labels:      labels,
+			Trackers:    trackers,
+			Size:        torrent.TotalSize,
+			SeedingTime: time.Duration(torrent.SeedingTime) * time.Second,
+			Ratio:       torrent.Ratio,
+			AddedDate:   time.Unix(int64(torrent.TimeAdded), 0),
+		})
+	}
+	return
+}
+
+func (d *Deluge) SetLocation(torrent Torrent, remoteSharePath string) (err error) {
+	if err = d.ensureConnected(); err != nil {
+		return
+	}
+	if err = d.rpcCall("core.move_storage", []interface{}{
+		[]string{torrent.Hash},
+		remoteSharePath,
+	}, nil); err != nil {
+		err = fmt.Errorf("'core.move_storage' rpc method failed: %v", err)
+	}
+	return
+}
+
+type delugeTorrent struct {
+	Name           string       `json:"name"`
+	SavePath       string       `json:"save_path"`
+	Progress       float64      `json:"progress"`
+	Files          []delugeFile `json:"files"`
+	FileProgress   []float64    `json:"file_progress"`
+	FilePriorities []int        `json:"file_priorities"`
+	Label          string       `json:"label"`
+	TrackerHost    string       `json:"tracker_host"`
+	TotalSize      int64        `json:"total_size"`
+	SeedingTime    int64        `json:"seeding_time"`
+	Ratio          float64      `json:"ratio"`
+	TimeAdded      float64      `json:"time_added"`
+}
+
+func delugePriority(priority int) int {
+	switch {
+	case priority >= delugeHighPriority:
+		return PriorityHigh
+	case priority < delugeNormalPriority:
+		return PriorityLow
+	}
+	return PriorityNormal
+}
+
+type delugeFile struct {
+	Index int    `json:"index"`
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+}
+
+type delugeRequest struct {
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+	ID     int           `json:"id"`
+}
+
+type delugeAnswer struct {
+	Result interface{}  `json:"result"`
+	Error  *delugeError `json:"error"`
+	ID     int          `json:"id"`
+}
+
+type delugeError struct {
+	Message string `json:"message"`
+	Code    int    `json:"code"`
+}
+
+func (e *delugeError) Error() string {
+	return fmt.Sprintf("deluge error %d: %s", e.Code, e.Message)
+}
+
+// ensureConnected makes sure the web UI is attached to a daemon, picking the
+// configured host (by id or "host:port") or the first known one otherwise.
+func (d *Deluge) ensureConnected() (err error) {
+	var connected bool
+	if err = d.rpcCall("web.connected", []interface{}{}, &connected); err != nil {
+		err = fmt.Errorf("'web.connected' rpc method failed: %v", err)
+		return
+	}
+	if connected {
+		return
+	}
+
+	var hosts [][]interface{}
+	if err = d.rpcCall("web.get_hosts", []interface{}{}, &hosts); err != nil {
+		err = fmt.Errorf("'web.get_hosts' rpc method failed: %v", err)
+		return
+	}
+
+	var hostID string
+	for _, host := range hosts {
+		if len(host) < 3 {
+			continue
+		}
+		id := fmt.Sprint(host[0])
+		address := fmt.Sprintf("%v:%v", host[1], host[2])
+		if d.host == "" || d.host == id || d.host == address {
+			hostID = id
+			break
+		}
+	}
+	if hostID == "" {
+		err = fmt.Errorf("no deluge daemon matching '%s'", d.host)
+		return
+	}
+
+	if err = d.rpcCall("web.connect", []interface{}{hostID}, nil); err != nil {
+		err = fmt.Errorf("'web.connect' rpc method failed: %v", err)
+	}
+	return
+}
+
+func (d *Deluge) login() (err error) {
+	var success bool
+	if err = d.request("auth.login", []interface{}{d.password}, &success, false); err != nil {
+		err = fmt.Errorf("'auth.login' rpc method failed: %v", err)
+		return
+	}
+	if !success {
+		err = errors.New("login refused: check password")
+	}
+	return
+}
+
+func (d *Deluge) rpcCall(method string, params []interface{}, result interface{}) (err error) {
+	return d.request(method, params, result, true)
+}
+
+func (d *Deluge) request(method string, params []interface{}, result interface{}, retry bool) (err error) {
+	if d.httpC == nil {
+		err = errors.New("this controller is not initialized, please use the New() function")
+		return
+	}
+
+	id := d.nextRequestID()
+	payload, err := json.Marshal(&delugeRequest{
+		Method: method,
+		Params: params,
+		ID:     id,
+	})
+	if err != nil {
+		err = fmt.Errorf("request payload JSON marshalling failed: %v", err)
+		return
+	}
+
+	var req *http.Request
+	if req, err = http.NewRequest("POST", d.url, bytes.NewReader(payload)); err != nil {
+		err = fmt.Errorf("can't prepare request for '%s' method: %v", method, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	var resp *http.Response
+	if resp, err = d.httpC.Do(req); err != nil {
+		err = fmt.Errorf("request error: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		err = fmt.Errorf("HTTP error %d: %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+		return
+	}
+
+	answer := delugeAnswer{
+		Result: result,
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		err = fmt.Errorf("can't unmarshall request answer body: %v", err)
+		return
+	}
+	if answer.ID != id {
+		err = errors.New("http request id and answer payload id do not match")
+		return
+	}
+	if answer.Error != nil {
+		// Is the session missing or expired ?
+		if answer.Error.Code == delugeNotAuthenticated && retry {
+			if err = d.login(); err != nil {
+				return
+			}
+			return d.request(method, params, result, false)
+		}
+		err = answer.Error
+	}
+	return
+}
+
+func (d *Deluge) nextRequestID() int {
+	defer d.requestLock.Unlock()
+	d.requestLock.Lock()
+	d.requestID++
+	return d.requestID
+}
@@ -0,0 +1,304 @@
+package provider
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDeluge serves the Deluge web JSON-RPC API, handing out a session cookie
+// on login and answering error code 1 to calls without it.
+type fakeDeluge struct {
+	t        *testing.T
+	session  string
+	sessions int
+	logins   int
+	refuse   bool
+	handlers map[string]func(params []json.RawMessage) interface{}
+	calls    []string
+	access   sync.Mutex
+}
+
+func newFakeDeluge(t *testing.T) (*fakeDeluge, *httptest.Server) {
+	f := &fakeDeluge{
+		t:        t,
+		handlers: map[string]func([]json.RawMessage) interface{}{},
+	}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	return f, server
+}
+
+func (f *fakeDeluge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.access.Lock()
+	defer f.access.Unlock()
+
+	var request struct {
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+		ID     int               `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		f.t.Errorf("invalid request: %v", err)
+	}
+	f.calls = append(f.calls, request.Method)
+	answer := map[string]interface{}{"id": request.ID, "result": nil, "error": nil}
+	defer writeJSON(w, answer)
+
+	if request.Method == "auth.login" {
+		f.logins++
+		var password string
+		if len(request.Params) == 1 {
+			_ = json.Unmarshal(request.Params[0], &password)
+		}
+		if password != "secret" {
+			answer["result"] = false
+			return
+		}
+		f.sessions++
+		f.session = strings.Repeat("s", f.sessions)
+		http.SetCookie(w, &http.Cookie{Name: "_session_id", Value: f.session, Path: "/"})
+		answer["result"] = true
+		return
+	}
+
+	cookie, err := r.Cookie("_session_id")
+	if f.refuse || err != nil || cookie.Value != f.session {
+		answer["error"] = map[string]interface{}{"message": "Not authenticated", "code": delugeNotAuthenticated}
+		return
+	}
+	handler, ok := f.handlers[request.Method]
+	if !ok {
+		answer["error"] = map[string]interface{}{"message": "Unknown method", "code": 2}
+		return
+	}
+	answer["result"] = handler(request.Params)
+}
+
+func (f *fakeDeluge) handle(method string, handler func(params []json.RawMessage) interface{}) {
+	f.handlers[method] = handler
+}
+
+// expire drops the current session, as the web UI does after a timeout.
+func (f *fakeDeluge) expire() {
+	f.access.Lock()
+	f.session = ""
+	f.access.Unlock()
+}
+
+// connected answers web.connected as attached to a daemon.
+func (f *fakeDeluge) connected() {
+	f.handle("web.connected", func(params []json.RawMessage) interface{} {
+		return true
+	})
+}
+
+func TestDelugeLogin(t *testing.T) {
+	f, server := newFakeDeluge(t)
+	f.connected()
+	f.handle("core.get_torrents_status", func(params []json.RawMessage) interface{} {
+		return map[string]interface{}{}
+	})
+	d := NewDeluge(server.URL, "secret", "")
+
+	// The first call has no session yet
+	if _, err := d.GetTorrents(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"web.connected", "auth.login", "web.connected", "core.get_torrents_status"}
+	if !reflect.DeepEqual(f.calls, want) {
+		t.Errorf("calls = %v, want %v", f.calls, want)
+	}
+
+	// An expired session is opened again
+	f.expire()
+	f.calls = nil
+	if _, err := d.GetTorrents(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(f.calls, want) {
+		t.Errorf("calls = %v, want %v", f.calls, want)
+	}
+	if f.logins != 2 {
+		t.Errorf("logins = %d, want 2", f.logins)
+	}
+}
+
+func TestDelugeLoginFailures(t *testing.T) {
+	f, server := newFakeDeluge(t)
+	f.connected()
+
+	_, err := NewDeluge(server.URL, "wrong", "").GetTorrents()
+	if err == nil || !strings.Contains(err.Error(), "login refused") {
+		t.Errorf("err = %v, want a refused login", err)
+	}
+
+	// A session refused right after the login is not asked again
+	f.refuse = true
+	f.logins = 0
+	_, err = NewDeluge(server.URL, "secret", "").GetTorrents()
+	if err == nil || !strings.Contains(err.Error(), "Not authenticated") {
+		t.Errorf("err = %v, want a refused session", err)
+	}
+	if f.logins != 1 {
+		t.Errorf("logins = %d, want 1", f.logins)
+	}
+}
+
+func TestDelugeHostSelection(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"", "first"},
+		{"second", "second"},
+		{"10.0.0.2:58846", "second"},
+		{"10.0.0.3:58846", ""},
+	}
+	for _, test := range tests {
+		f, server := newFakeDeluge(t)
+		f.handle("web.connected", func(params []json.RawMessage) interface{} {
+			return false
+		})
+		f.handle("web.get_hosts", func(params []json.RawMessage) interface{} {
+			return [][]interface{}{
+				{"first", "10.0.0.1", 58846, "Online"},
+				{"incomplete"},
+				{"second", "10.0.0.2", 58846, "Online"},
+			}
+		})
+		var connected string
+		f.handle("web.connect", func(params []json.RawMessage) interface{} {
+			if len(params) == 1 {
+				_ = json.Unmarshal(params[0], &connected)
+			}
+			return nil
+		})
+		f.handle("core.move_storage", func(params []json.RawMessage) interface{} {
+			return nil
+		})
+
+		err := NewDeluge(server.URL, "secret", test.host).SetLocation(Torrent{Hash: "abcd"}, "/share")
+		if test.want == "" {
+			if err == nil || !strings.Contains(err.Error(), "no deluge daemon") {
+				t.Errorf("host %q: err = %v, want no daemon", test.host, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("host %q: %v", test.host, err)
+			continue
+		}
+		if connected != test.want {
+			t.Errorf("host %q connected to %q, want %q", test.host, connected, test.want)
+		}
+	}
+}
+
+func TestDelugeConnected(t *testing.T) {
+	f, server := newFakeDeluge(t)
+	f.connected()
+	f.handle("core.get_torrents_status", func(params []json.RawMessage) interface{} {
+		return map[string]interface{}{}
+	})
+
+	// A web UI already attached to a daemon is left alone
+	if _, err := NewDeluge(server.URL, "secret", "other").GetTorrents(); err != nil {
+		t.Fatal(err)
+	}
+	for _, call := range f.calls {
+		if call == "web.get_hosts" || call == "web.connect" {
+			t.Errorf("calls = %v, want no host selection", f.calls)
+		}
+	}
+}
+
+func TestDelugeGetTorrents(t *testing.T) {
+	f, server := newFakeDeluge(t)
+	f.connected()
+	f.handle("core.get_torrents_status", func(params []json.RawMessage) interface{} {
+		return map[string]interface{}{
+			"abcd": map[string]interface{}{
+				"name":      "Movie",
+				"save_path": "/data/complete/",
+				"progress":  50,
+				"files": []map[string]interface{}{
+					{"index": 0, "path": "Movie/a.mkv", "size": 200},
+					{"index": 1, "path": "Movie/b.nfo", "size": 100},
+					{"index": 2, "path": "Movie/c.srt", "size": 10},
+					{"index": 3, "path": "Movie/d.txt", "size": 10},
+				},
+				// Older daemons may give fewer progresses or priorities
+				"file_progress":   []float64{1, 0.5, 0},
+				"file_priorities": []int{4, 0, 7},
+				"label":           "movies",
+				"tracker_host":    "example.org",
+				"total_size":      320,
+				"seeding_time":    90,
+				"ratio":           1.5,
+				"time_added":      1600000000.5,
+			},
+		}
+	})
+	d := NewDeluge(server.URL, "secret", "")
+
+	torrents, err := d.GetTorrents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Torrent{{
+		Hash:        "abcd",
+		Name:        "Movie",
+		PercentDone: 0.5,
+		Files: []TorrentFile{
+			{Name: "Movie/a.mkv", Length: 200, BytesCompleted: 200, Wanted: true, Priority: PriorityNormal},
+			{Name: "Movie/b.nfo", Length: 100, BytesCompleted: 50, Wanted: false, Priority: PriorityLow},
+			{Name: "Movie/c.srt", Length: 10, BytesCompleted: 0, Wanted: true, Priority: PriorityHigh},
+			{Name: "Movie/d.txt", Length: 10, BytesCompleted: 0, Wanted: true, Priority: PriorityNormal},
+		},
+		DownloadDir: "/data/complete",
+		Labels:      []string{"movies"},
+		Trackers:    []string{"example.org"},
+		Size:        320,
+		SeedingTime: 90 * time.Second,
+		Ratio:       1.5,
+		AddedDate:   time.Unix(1600000000, 0),
+	}}
+	if !reflect.DeepEqual(torrents, want) {
+		t.Errorf("torrents = %+v\nwant %+v", torrents, want)
+	}
+}
+
+func TestDelugeSetLocation(t *testing.T) {
+	f, server := newFakeDeluge(t)
+	f.connected()
+	var hashes []string
+	var location string
+	f.handle("core.move_storage", func(params []json.RawMessage) interface{} {
+		if len(params) != 2 {
+			t.Errorf("move_storage params = %s", params)
+			return nil
+		}
+		_ = json.Unmarshal(params[0], &hashes)
+		_ = json.Unmarshal(params[1], &location)
+		return nil
+	})
+	d := NewDeluge(server.URL, "secret", "")
+
+	if err := d.SetLocation(Torrent{Hash: "abcd"}, "/data/share"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(hashes, []string{"abcd"}) || location != "/data/share" {
+		t.Errorf("moved %v to %q", hashes, location)
+	}
+
+	delete(f.handlers, "core.move_storage")
+	if err := d.SetLocation(Torrent{Hash: "abcd"}, "/data/share"); err == nil {
+		t.Error("a failed move is not reported")
+	}
+}
@@ -0,0 +1,177 @@
+package provider
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+)
+
+const DefaultStablePeriod = time.Minute
+
+type RemoteEntry struct {
+	Name    string
+	Size    int64
+	IsDir   bool
+	ModTime time.Time
+}
+
+type RemoteFileSystem interface {
+	List(path string) (entries []RemoteEntry, err error)
+	Rename(from string, to string) (err error)
+	// Mkdir creates a directory along with its missing parents, doing
+	// nothing when it already exists.
+	Mkdir(path string) (err error)
+}
+
+type Directory struct {
+	fs                 RemoteFileSystem
+	paths              []string
+	stablePeriod       time.Duration
+	observations       map[string]observation
+	observationsAccess sync.Mutex
+}
+
+type observation struct {
+	size  int64
+	since time.Time
+}
+
+func NewDirectory(fs RemoteFileSystem, paths []string, stablePeriod time.Duration) *Directory {
+	return &Directory{
+		fs:           fs,
+		paths:        paths,
+		stablePeriod: stablePeriod,
+		observations: map[string]observation{},
+	}
+}
+
+func (d *Directory) GetTorrents() (torrents []Torrent, err error) {
+	defer d.observationsAccess.Unlock()
+	d.observationsAccess.Lock()
+
+	now := time.Now()
+	observations := map[string]observation{}
+	visited := map[string]bool{}
+	for _, remotePath := range d.paths {
+		if visited[remotePath] {
+			continue
+		}
+		visited[remotePath] = true
+
+		var entries []RemoteEntry
+		if entries, err = d.fs.List(remotePath); err != nil {
+			err = fmt.Errorf("unable to list '%s': %v", remotePath, err)
+			return
+		}
+
+		for _, entry := range entries {
+			var files []TorrentFile
+			var newest time.Time
+			if entry.IsDir {
+				if files, newest, err = d.walk(remotePath+"/"+entry.Name, entry.Name); err != nil {
+					return
+				}
+			} else {
+				files = []TorrentFile{{Name: entry.Name, Length: entry.Size, Wanted: true}}
+				newest = entry.ModTime
+			}
+
+			var size int64
+			for _, file := range files {
+				size += file.Length
+			}
+
+			// An entry is considered complete once its size did not change
+			// during the stable period, its modification time giving a head
+			// start the first time it is seen
+			key := remotePath + "/" + entry.Name
+			o, known := d.observations[key]
+			if !known || o.size != size {
+				o = observation{size: size, since: now}
+				if !known && !newest.IsZero() && newest.Before(now) {
+					o.since = newest
+				}
+			}
+			observations[key] = o
+
+			var percentDone float64
+			if now.Sub(o.since) >= d.stablePeriod {
+				percentDone = 1
+				for i := range files {
+					files[i].BytesCompleted = files[i].Length
+				}
+			}
+
+			hash := sha1.Sum([]byte(key))
+			torrents = append(torrents, Torrent{
+				Hash:        hex.EncodeToString(hash[:]),
+				Name:        entry.Name,
+				PercentDone: percentDone,
+				Files:       files,
+				DownloadDir: remotePath,
+				Size:        size,
+				AddedDate:   newest,
+			})
+		}
+	}
+	d.observations = observations
+	return
+}
+
+func (d *Directory) walk(remotePath string, name string) (files []TorrentFile, newest time.Time, err error) {
+	var entries []RemoteEntry
+	if entries, err = d.fs.List(remotePath); err != nil {
+		err = fmt.Errorf("unable to list '%s': %v", remotePath, err)
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir {
+			var subFiles []TorrentFile
+			var subNewest time.Time
+			if subFiles, subNewest, err = d.walk(remotePath+"/"+entry.Name, path.Join(name, entry.Name)); err != nil {
+				return
+			}
+			files = append(files, subFiles...)
+			if subNewest.After(newest) {
+				newest = subNewest
+			}
+			continue
+		}
+		files = append(files, TorrentFile{
+			Name:   path.Join(name, entry.Name),
+			Length: entry.Size,
+			Wanted: true,
+		})
+		if entry.ModTime.After(newest) {
+			newest = entry.ModTime
+		}
+	}
+	return
+}
+
+func (d *Directory) SetLocation(torrent Torrent, remoteSharePath string) (err error) {
+	if err = d.fs.Mkdir(remoteSharePath); err != nil {
+		err = fmt.Errorf("unable to create '%s': %v", remoteSharePath, err)
+		return
+	}
+	// Older data at the destination must neither be kept in place of the
+	// torrent data nor replaced
+	entries, err := d.fs.List(remoteSharePath)
+	if err != nil {
+		err = fmt.Errorf("unable to list '%s': %v", remoteSharePath, err)
+		return
+	}
+	for _, entry := range entries {
+		if entry.Name == torrent.Name {
+			err = fmt.Errorf("'%s' already exists in '%s'", torrent.Name, remoteSharePath)
+			return
+		}
+	}
+	if err = d.fs.Rename(torrent.DownloadDir+"/"+torrent.Name, remoteSharePath+"/"+torrent.Name); err != nil {
+		err = fmt.Errorf("unable to move '%s' to '%s': %v", torrent.Name, remoteSharePath, err)
+	}
+	return
+}
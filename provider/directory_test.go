@@ -0,0 +1,66 @@
+package provider
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// dirFileSystem is a remote file system kept in a local directory.
+type dirFileSystem string
+
+func (fs dirFileSystem) local(path string) string {
+	return filepath.Join(string(fs), filepath.FromSlash(path))
+}
+
+func (fs dirFileSystem) List(path string) (entries []RemoteEntry, err error) {
+	infos, err := ioutil.ReadDir(fs.local(path))
+	for _, info := range infos {
+		entries = append(entries, RemoteEntry{Name: info.Name(), Size: info.Size(), IsDir: info.IsDir(), ModTime: info.ModTime()})
+	}
+	return
+}
+
+func (fs dirFileSystem) Rename(from string, to string) error {
+	return os.Rename(fs.local(from), fs.local(to))
+}
+
+func (fs dirFileSystem) Mkdir(path string) error {
+	return os.MkdirAll(fs.local(path), 0755)
+}
+
+func TestDirectorySetLocation(t *testing.T) {
+	fs := dirFileSystem(t.TempDir())
+	for _, name := range []string{"/complete/Movie/a.mkv", "/complete/Show/b.mkv", "/share/Show/old.mkv"} {
+		if err := os.MkdirAll(filepath.Dir(fs.local(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fs.local(name), []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := NewDirectory(fs, []string{"/complete"}, time.Minute)
+
+	// The share path is created on the first move
+	movie := Torrent{Name: "Movie", DownloadDir: "/complete"}
+	if err := d.SetLocation(movie, "/share/movies"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(fs.local("/share/movies/Movie/a.mkv")); err != nil {
+		t.Errorf("torrent not moved: %v", err)
+	}
+
+	// Older data with the same name is left alone
+	show := Torrent{Name: "Show", DownloadDir: "/complete"}
+	if err := d.SetLocation(show, "/share"); err == nil {
+		t.Error("existing target overwritten")
+	}
+	if _, err := os.Stat(fs.local("/complete/Show/b.mkv")); err != nil {
+		t.Errorf("torrent moved: %v", err)
+	}
+	if _, err := os.Stat(fs.local("/share/Show/old.mkv")); err != nil {
+		t.Errorf("older data lost: %v", err)
+	}
+}
@@ -1,6 +1,19 @@
 package provider
 
+import "errors"
+
+// ErrNoMetainfo is returned by providers which cannot give the .torrent file
+// of a torrent, as opposed to failing to get it.
+var ErrNoMetainfo = errors.New("the provider does not give the torrent metainfo")
+
 type Provider interface {
 	GetTorrents() (torrents []Torrent, err error)
 	SetLocation(torrent Torrent, remoteSharePath string) (err error)
 }
+
+// MetainfoExporter is implemented by providers able to give the .torrent file
+// of a torrent themselves, the others may tell where to download it from in
+// Torrent.MetainfoPath.
+type MetainfoExporter interface {
+	ExportMetainfo(torrent Torrent) (metainfo []byte, err error)
+}
@@ -0,0 +1,273 @@
+package provider
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/go-cleanhttp"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type QBittorrent struct {
+	url         string
+	user        string
+	password    string
+	httpC       *http.Client
+	loginAccess sync.Mutex
+}
+
+func NewQBittorrent(url, username, password string) *QBittorrent {
+	httpC := cleanhttp.DefaultPooledClient()
+	httpC.Jar, _ = cookiejar.New(nil)
+	return &QBittorrent{
+		url:      strings.TrimRight(url, "/"),
+		user:     username,
+		password: password,
+		httpC:    httpC,
+	}
+}
+
+func (q *QBittorrent) GetTorrents() (torrents []Torrent, err error) {
+	var result []qbittorrentTorrent
+	if err = q.apiCall("torrents/info", nil, &result); err != nil {
+		err = fmt.Errorf("'torrents/info' api method failed: %v", err)
+		return
+	}
+
+	torrents = make([]Torrent, len(result))
+	for i, torrent := range result {
+		var resultFiles []qbittorrentFile
+		if err = q.apiCall("torrents/files", url.Values{"hash": {torrent.Hash}}, &resultFiles); err != nil {
+			err = fmt.Errorf("'torrents/files' api method failed: %v", err)
+			return
+		}
+
+		var files = make([]TorrentFile, len(resultFiles))
+		for j, file := range resultFiles {
+			files[j] = TorrentFile{
+				Name:           file.Name,
+				Length:         file.Size,
+				BytesCompleted: completedBytes(file.Size, file.Progress),
+				Wanted:         file.Priority != 0,
+				Priority:       qbittorrentPriority(file.Priority),
+			}
+		}
+
+		var resultTrackers []qbittorrentTracker
+		if err = q.apiCall("torrents/trackers", url.Values{"hash": {torrent.Hash}}, &resultTrackers); err != nil {
+			err = fmt.Errorf("'torrents/trackers' api method failed: %v", err)
+			return
+		}
+
+		var trackers []string
+		for _, tracker := range resultTrackers {
+			// DHT, PeX and LSD are listed as pseudo trackers
+			if !strings.HasPrefix(tracker.Url, "**") {
+				trackers = append(trackers, trackerHost(tracker.Url))
+			}
+		}
+
+		// Both the category and the tags act as labels
+		var labels []string
+		if torrent.Category != "" {
+			labels = append(labels, torrent.Category)
+		}
+		for _, tag := range strings.Split(torrent.Tags, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				labels = append(labels, tag)
+			}
+		}
+
+		torrents[i] = Torrent{
+			Hash:        torrent.Hash,
+			Name:        torrent.Name,
+			PercentDone: torrent.Progress,
+			Files:       files,
+			DownloadDir: strings.TrimRight(torrent.SavePath, "/"),
+			Labels:      labels,
+			Trackers:    trackers,
+			Size:        torrent.TotalSize,
+			SeedingTime: time.Duration(torrent.SeedingTime) * time.Second,
+			Ratio:       torrent.Ratio,
+			AddedDate:   time.Unix(torrent.AddedOn, 0),
+		}
+	}
+	return
+}
+
+func (q *QBittorrent) SetLocation(torrent Torrent, remoteSharePath string) (err error) {
+	if err = q.apiCall("torrents/setLocation", url.Values{
+		"hashes":   {torrent.Hash},
+		"location": {remoteSharePath},
+	}, nil); err != nil {
+		err = fmt.Errorf("'torrents/setLocation' api method failed: %v", err)
+	}
+	return
+}
+
+// ExportMetainfo needs qBittorrent 4.5 or later, older versions answering
+// 404 as for an unknown torrent.
+func (q *QBittorrent) ExportMetainfo(torrent Torrent) (metainfo []byte, err error) {
+	// The .torrent file is answered as is, not as JSON
+	err = q.request("torrents/export", url.Values{"hash": {torrent.Hash}}, func(body io.Reader) (e error) {
+		metainfo, e = ioutil.ReadAll(body)
+		return
+	}, true)
+	if status, ok := err.(qbittorrentStatusError); ok && status == http.StatusNotFound {
+		err = ErrNoMetainfo
+	} else if err != nil {
+		err = fmt.Errorf("'torrents/export' api method failed: %v", err)
+	}
+	return
+}
+
+type qbittorrentTorrent struct {
+	Hash        string  `json:"hash"`
+	Name        string  `json:"name"`
+	Progress    float64 `json:"progress"`
+	SavePath    string  `json:"save_path"`
+	Category    string  `json:"category"`
+	Tags        string  `json:"tags"`
+	TotalSize   int64   `json:"total_size"`
+	SeedingTime int64   `json:"seeding_time"`
+	Ratio       float64 `json:"ratio"`
+	AddedOn     int64   `json:"added_on"`
+}
+
+type qbittorrentTracker struct {
+	Url string `json:"url"`
+}
+
+type qbittorrentFile struct {
+	Name     string  `json:"name"`
+	Size     int64   `json:"size"`
+	Progress float64 `json:"progress"`
+	Priority int     `json:"priority"`
+}
+
+// qbittorrentPriority maps the file priorities (0 skipped, 1 normal, 6 high,
+// 7 maximal) to the Transmission ones.
+func qbittorrentPriority(priority int) int {
+	if priority > 1 {
+		return PriorityHigh
+	}
+	return PriorityNormal
+}
+
+// completedBytes converts a progress ratio into a byte count, making sure a
+// finished file reports exactly its length despite floating point rounding.
+func completedBytes(length int64, progress float64) int64 {
+	if progress >= 1 {
+		return length
+	}
+	return int64(float64(length) * progress)
+}
+
+func (q *QBittorrent) apiCall(method string, params url.Values, result interface{}) (err error) {
+	var read func(io.Reader) error
+	if result != nil {
+		read = func(body io.Reader) error {
+			return json.NewDecoder(body).Decode(result)
+		}
+	}
+	return q.request(method, params, read, true)
+}
+
+func (q *QBittorrent) request(method string, params url.Values, read func(io.Reader) error, retry bool) (err error) {
+	if q.httpC == nil {
+		err = errors.New("this controller is not initialized, please use the New() function")
+		return
+	}
+	if params == nil {
+		params = url.Values{}
+	}
+
+	var req *http.Request
+	if req, err = http.NewRequest("POST", q.url+"/api/v2/"+method, strings.NewReader(params.Encode())); err != nil {
+		err = fmt.Errorf("can't prepare request for '%s' method: %v", method, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Referer", q.url)
+
+	var resp *http.Response
+	if resp, err = q.httpC.Do(req); err != nil {
+		err = fmt.Errorf("request error: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	// Is the session missing or expired ?
+	if resp.StatusCode == http.StatusForbidden {
+		if !retry {
+			err = errors.New("session refused 2 times in a row: stopping to avoid infinite loop")
+			return
+		}
+		if err = q.login(); err != nil {
+			return
+		}
+		return q.request(method, params, read, false)
+	}
+	if resp.StatusCode != 200 {
+		err = qbittorrentStatusError(resp.StatusCode)
+		return
+	}
+
+	if read == nil {
+		return
+	}
+	if err = read(resp.Body); err != nil {
+		err = fmt.Errorf("can't read request answer body: %v", err)
+	}
+	return
+}
+
+type qbittorrentStatusError int
+
+func (e qbittorrentStatusError) Error() string {
+	return fmt.Sprintf("HTTP error %d: %s", int(e), http.StatusText(int(e)))
+}
+
+func (q *QBittorrent) login() (err error) {
+	defer q.loginAccess.Unlock()
+	q.loginAccess.Lock()
+
+	var req *http.Request
+	if req, err = http.NewRequest("POST", q.url+"/api/v2/auth/login", strings.NewReader(url.Values{
+		"username": {q.user},
+		"password": {q.password},
+	}.Encode())); err != nil {
+		err = fmt.Errorf("can't prepare login request: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Referer", q.url)
+
+	var resp *http.Response
+	if resp, err = q.httpC.Do(req); err != nil {
+		err = fmt.Errorf("login request error: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		err = fmt.Errorf("login HTTP error %d: %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+		return
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		err = fmt.Errorf("can't read login answer body: %v", err)
+		return
+	}
+	if strings.TrimSpace(string(body)) != "Ok." {
+		err = errors.New("login refused: check username and password")
+	}
+	return
+}
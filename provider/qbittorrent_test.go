@@ -0,0 +1,291 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeQBittorrent serves the qBittorrent web API, handing out a session
+// cookie on login and answering 403 to requests without it.
+type fakeQBittorrent struct {
+	t        *testing.T
+	sid      string
+	sessions int
+	logins   int
+	refuse   bool
+	handlers map[string]func(w http.ResponseWriter, form url.Values)
+	calls    []string
+	access   sync.Mutex
+}
+
+func newFakeQBittorrent(t *testing.T) (*fakeQBittorrent, *httptest.Server) {
+	f := &fakeQBittorrent{
+		t:        t,
+		handlers: map[string]func(http.ResponseWriter, url.Values){},
+	}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	return f, server
+}
+
+func (f *fakeQBittorrent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.access.Lock()
+	defer f.access.Unlock()
+
+	if err := r.ParseForm(); err != nil {
+		f.t.Errorf("invalid form: %v", err)
+	}
+	method := strings.TrimPrefix(r.URL.Path, "/api/v2/")
+	f.calls = append(f.calls, method)
+
+	if method == "auth/login" {
+		f.logins++
+		if r.PostForm.Get("username") != "user" || r.PostForm.Get("password") != "secret" {
+			w.Write([]byte("Fails."))
+			return
+		}
+		f.sessions++
+		f.sid = fmt.Sprint("session", f.sessions)
+		http.SetCookie(w, &http.Cookie{Name: "SID", Value: f.sid, Path: "/"})
+		w.Write([]byte("Ok."))
+		return
+	}
+
+	cookie, err := r.Cookie("SID")
+	if f.refuse || err != nil || cookie.Value != f.sid {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	handler, ok := f.handlers[method]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	handler(w, r.PostForm)
+}
+
+// expire drops the current session, as qBittorrent does after a timeout.
+func (f *fakeQBittorrent) expire() {
+	f.access.Lock()
+	f.sid = ""
+	f.access.Unlock()
+}
+
+func (f *fakeQBittorrent) handle(method string, handler func(w http.ResponseWriter, form url.Values)) {
+	f.handlers[method] = handler
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
+
+func TestQBittorrentLogin(t *testing.T) {
+	f, server := newFakeQBittorrent(t)
+	f.handle("torrents/info", func(w http.ResponseWriter, form url.Values) {
+		writeJSON(w, []interface{}{})
+	})
+	q := NewQBittorrent(server.URL+"/", "user", "secret")
+
+	// The first request has no session yet
+	if _, err := q.GetTorrents(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"torrents/info", "auth/login", "torrents/info"}
+	if !reflect.DeepEqual(f.calls, want) {
+		t.Errorf("calls = %v, want %v", f.calls, want)
+	}
+
+	// Then the cookie is sent along
+	f.calls = nil
+	if _, err := q.GetTorrents(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"torrents/info"}; !reflect.DeepEqual(f.calls, want) {
+		t.Errorf("calls = %v, want %v", f.calls, want)
+	}
+	if f.logins != 1 {
+		t.Errorf("logins = %d, want 1", f.logins)
+	}
+}
+
+func TestQBittorrentRelogin(t *testing.T) {
+	f, server := newFakeQBittorrent(t)
+	f.handle("torrents/info", func(w http.ResponseWriter, form url.Values) {
+		writeJSON(w, []interface{}{})
+	})
+	q := NewQBittorrent(server.URL, "user", "secret")
+	if _, err := q.GetTorrents(); err != nil {
+		t.Fatal(err)
+	}
+
+	f.expire()
+	f.calls = nil
+	if _, err := q.GetTorrents(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"torrents/info", "auth/login", "torrents/info"}
+	if !reflect.DeepEqual(f.calls, want) {
+		t.Errorf("calls = %v, want %v", f.calls, want)
+	}
+	if f.logins != 2 {
+		t.Errorf("logins = %d, want 2", f.logins)
+	}
+}
+
+func TestQBittorrentForbiddenTwice(t *testing.T) {
+	f, server := newFakeQBittorrent(t)
+	f.refuse = true
+	q := NewQBittorrent(server.URL, "user", "secret")
+
+	_, err := q.GetTorrents()
+	if err == nil || !strings.Contains(err.Error(), "refused 2 times") {
+		t.Fatalf("err = %v, want a refused session", err)
+	}
+	if f.logins != 1 {
+		t.Errorf("logins = %d, want 1", f.logins)
+	}
+}
+
+func TestQBittorrentLoginRefused(t *testing.T) {
+	f, server := newFakeQBittorrent(t)
+	q := NewQBittorrent(server.URL, "user", "wrong")
+
+	_, err := q.GetTorrents()
+	if err == nil || !strings.Contains(err.Error(), "login refused") {
+		t.Fatalf("err = %v, want a refused login", err)
+	}
+	if want := []string{"torrents/info", "auth/login"}; !reflect.DeepEqual(f.calls, want) {
+		t.Errorf("calls = %v, want %v", f.calls, want)
+	}
+}
+
+func TestQBittorrentGetTorrents(t *testing.T) {
+	f, server := newFakeQBittorrent(t)
+	f.handle("torrents/info", func(w http.ResponseWriter, form url.Values) {
+		writeJSON(w, []map[string]interface{}{{
+			"hash":         "abcd",
+			"name":         "Movie",
+			"progress":     1,
+			"save_path":    "/data/complete/",
+			"category":     "movies",
+			"tags":         "hd, new",
+			"total_size":   300,
+			"seeding_time": 90,
+			"ratio":        1.5,
+			"added_on":     1600000000,
+		}})
+	})
+	f.handle("torrents/files", func(w http.ResponseWriter, form url.Values) {
+		if form.Get("hash") != "abcd" {
+			t.Errorf("files asked for %q", form.Get("hash"))
+		}
+		writeJSON(w, []map[string]interface{}{
+			{"name": "Movie/a.mkv", "size": 200, "progress": 1, "priority": 1},
+			{"name": "Movie/b.nfo", "size": 100, "progress": 0.5, "priority": 0},
+			{"name": "Movie/c.srt", "size": 0, "progress": 1, "priority": 7},
+		})
+	})
+	f.handle("torrents/trackers", func(w http.ResponseWriter, form url.Values) {
+		writeJSON(w, []map[string]interface{}{
+			{"url": "** [DHT] **"},
+			{"url": "https://tracker.example.org:443/announce"},
+		})
+	})
+	q := NewQBittorrent(server.URL, "user", "secret")
+
+	torrents, err := q.GetTorrents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Torrent{{
+		Hash:        "abcd",
+		Name:        "Movie",
+		PercentDone: 1,
+		Files: []TorrentFile{
+			{Name: "Movie/a.mkv", Length: 200, BytesCompleted: 200, Wanted: true, Priority: PriorityNormal},
+			{Name: "Movie/b.nfo", Length: 100, BytesCompleted: 50, Wanted: false, Priority: PriorityNormal},
+			{Name: "Movie/c.srt", Length: 0, BytesCompleted: 0, Wanted: true, Priority: PriorityHigh},
+		},
+		DownloadDir: "/data/complete",
+		Labels:      []string{"movies", "hd", "new"},
+		Trackers:    []string{trackerHost("https://tracker.example.org:443/announce")},
+		Size:        300,
+		SeedingTime: 90 * time.Second,
+		Ratio:       1.5,
+		AddedDate:   time.Unix(1600000000, 0),
+	}}
+	if !reflect.DeepEqual(torrents, want) {
+		t.Errorf("torrents = %+v\nwant %+v", torrents, want)
+	}
+}
+
+func TestQBittorrentSetLocation(t *testing.T) {
+	f, server := newFakeQBittorrent(t)
+	var form url.Values
+	f.handle("torrents/setLocation", func(w http.ResponseWriter, values url.Values) {
+		form = values
+	})
+	q := NewQBittorrent(server.URL, "user", "secret")
+
+	if err := q.SetLocation(Torrent{Hash: "abcd"}, "/data/share"); err != nil {
+		t.Fatal(err)
+	}
+	if form.Get("hashes") != "abcd" || form.Get("location") != "/data/share" {
+		t.Errorf("setLocation form = %v", form)
+	}
+
+	f.handle("torrents/setLocation", func(w http.ResponseWriter, values url.Values) {
+		w.WriteHeader(http.StatusConflict)
+	})
+	if err := q.SetLocation(Torrent{Hash: "abcd"}, "/data/share"); err == nil {
+		t.Error("a failed move is not reported")
+	}
+}
+
+func TestQBittorrentExportMetainfo(t *testing.T) {
+	f, server := newFakeQBittorrent(t)
+	metainfo := []byte("d4:infod6:lengthi3e4:name1:a12:piece lengthi16384e6:pieces0:ee")
+	f.handle("torrents/export", func(w http.ResponseWriter, form url.Values) {
+		if form.Get("hash") != "abcd" {
+			t.Errorf("export asked for %q", form.Get("hash"))
+		}
+		w.Header().Set("Content-Type", "application/x-bittorrent")
+		w.Write(metainfo)
+	})
+	q := NewQBittorrent(server.URL, "user", "secret")
+
+	data, err := q.ExportMetainfo(Torrent{Hash: "abcd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, metainfo) {
+		t.Errorf("metainfo = %q, want %q", data, metainfo)
+	}
+}
+
+func TestQBittorrentExportMetainfoUnsupported(t *testing.T) {
+	f, server := newFakeQBittorrent(t)
+	q := NewQBittorrent(server.URL, "user", "secret")
+
+	// Versions before 4.5 do not know the method
+	if _, err := q.ExportMetainfo(Torrent{Hash: "abcd"}); err != ErrNoMetainfo {
+		t.Errorf("error = %v, want %v", err, ErrNoMetainfo)
+	}
+
+	f.handle("torrents/export", func(w http.ResponseWriter, form url.Values) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	if _, err := q.ExportMetainfo(Torrent{Hash: "abcd"}); err == nil || err == ErrNoMetainfo {
+		t.Errorf("error = %v, want a failure", err)
+	}
+}
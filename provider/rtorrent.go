@@ -0,0 +1,331 @@
+package provider
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/go-cleanhttp"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+type RTorrent struct {
+	url      string
+	user     string
+	password string
+	httpC    *http.Client
+}
+
+func NewRTorrent(url, username, password string) *RTorrent {
+	return &RTorrent{
+		url:      url,
+		user:     username,
+		password: password,
+		httpC:    cleanhttp.DefaultPooledClient(),
+	}
+}
+
+func (r *RTorrent) GetTorrents() (torrents []Torrent, err error) {
+	var result interface{}
+	if result, err = r.rpcCall("d.multicall2", "", "main",
+		"d.hash=",
+		"d.name=",
+		"d.directory=",
+		"d.complete=",
+		"d.is_multi_file=",
+		"d.completed_bytes=",
+		"d.size_bytes=",
+		"d.chunk_size=",
+		"d.custom1=",
+		"d.ratio=",
+		"d.load_date=",
+		"d.timestamp.finished=",
+		"d.session_file=",
+	); err != nil {
+		err = fmt.Errorf("'d.multicall2' rpc method failed: %v", err)
+		return
+	}
+
+	rows, ok := result.([]interface{})
+	if !ok {
+		err = errors.New("'d.multicall2' rpc method returned an unexpected payload")
+		return
+	}
+
+	torrents = make([]Torrent, len(rows))
+	for i, row := range rows {
+		var torrent rtorrentTorrent
+		if torrent, err = newRTorrentTorrent(row); err != nil {
+			err = fmt.Errorf("'d.multicall2' rpc method returned an unexpected payload: %v", err)
+			return
+		}
+
+		// Multi-file torrents report their own folder as directory
+		downloadDir := torrent.directory
+		prefix := ""
+		if torrent.multiFile {
+			downloadDir = path.Dir(torrent.directory)
+			prefix = path.Base(torrent.directory) + "/"
+		}
+
+		var files []TorrentFile
+		if files, err = r.getFiles(torrent, prefix); err != nil {
+			return
+		}
+
+		var trackers []string
+		if trackers, err = r.getTrackers(torrent); err != nil {
+			return
+		}
+
+		// ruTorrent keeps its label URL encoded in the first custom field
+		var labels []string
+		if label, e := url.QueryUnescape(torrent.custom1); e == nil && label != "" {
+			labels = []string{label}
+		}
+
+		var seedingTime time.Duration
+		if torrent.finished > 0 {
+			seedingTime = time.Since(time.Unix(torrent.finished, 0))
+		}
+
+		var percentDone float64
+		if torrent.complete {
+			percentDone = 1
+		} else if torrent.sizeBytes > 0 {
+			percentDone = float64(torrent.completedBytes) / float64(torrent.sizeBytes)
+		}
+
+		torrents[i] = Torrent{
+			Hash:         torrent.hash,
+			Name:         torrent.name,
+			PercentDone:  percentDone,
+			Files:        files,
+			DownloadDir:  strings.TrimRight(downloadDir, "/"),
+			Labels:       labels,
+			Trackers:     trackers,
+			Size:         torrent.sizeBytes,
+			SeedingTime:  seedingTime,
+			Ratio:        float64(torrent.ratio) / 1000,
+			AddedDate:    time.Unix(torrent.loadDate, 0),
+			MetainfoPath: torrent.sessionFile,
+		}
+	}
+	return
+}
+
+func (r *RTorrent) getTrackers(torrent rtorrentTorrent) (trackers []string, err error) {
+	var result interface{}
+	if result, err = r.rpcCall("t.multicall", torrent.hash, "",
+		"t.url=",
+	); err != nil {
+		err = fmt.Errorf("'t.multicall' rpc method failed: %v", err)
+		return
+	}
+
+	rows, _ := result.([]interface{})
+	for _, row := range rows {
+		values, _ := row.([]interface{})
+		if len(values) < 1 {
+			err = errors.New("'t.multicall' rpc method returned an unexpected payload")
+			return
+		}
+		// DHT shows up as a pseudo tracker
+		if announce, _ := values[0].(string); announce != "" && announce != "dht://" {
+			trackers = append(trackers, trackerHost(announce))
+		}
+	}
+	return
+}
+
+func (r *RTorrent) getFiles(torrent rtorrentTorrent, prefix string) (files []TorrentFile, err error) {
+	var result interface{}
+	if result, err = r.rpcCall("f.multicall", torrent.hash, "",
+		"f.path=",
+		"f.size_bytes=",
+		"f.completed_chunks=",
+		"f.size_chunks=",
+		"f.priority=",
+	); err != nil {
+		err = fmt.Errorf("'f.multicall' rpc method failed: %v", err)
+		return
+	}
+
+	rows, _ := result.([]interface{})
+	files = make([]TorrentFile, len(rows))
+	for i, row := range rows {
+		values, _ := row.([]interface{})
+		if len(values) < 5 {
+			err = errors.New("'f.multicall' rpc method returned an unexpected payload")
+			return
+		}
+		name, _ := values[0].(string)
+		size, _ := values[1].(int64)
+		completedChunks, _ := values[2].(int64)
+		sizeChunks, _ := values[3].(int64)
+		// Files are off (0), normal (1) or high (2)
+		priority, _ := values[4].(int64)
+
+		bytesCompleted := size
+		if completedChunks < sizeChunks {
+			bytesCompleted = completedChunks * torrent.chunkSize
+			if bytesCompleted > size {
+				bytesCompleted = size
+			}
+		}
+
+		files[i] = TorrentFile{
+			Name:           prefix + name,
+			Length:         size,
+			BytesCompleted: bytesCompleted,
+			Wanted:         priority != 0,
+			Priority:       int(priority) - 1,
+		}
+	}
+	return
+}
+
+func (r *RTorrent) SetLocation(torrent Torrent, remoteSharePath string) (err error) {
+	var result interface{}
+	if result, err = r.rpcCall("d.base_path", torrent.Hash); err != nil {
+		err = fmt.Errorf("'d.base_path' rpc method failed: %v", err)
+		return
+	}
+	basePath, _ := result.(string)
+	if basePath == "" {
+		err = errors.New("torrent data is not opened, unable to locate it")
+		return
+	}
+
+	call := func(method string, params ...interface{}) error {
+		if _, err := r.rpcCall(method, params...); err != nil {
+			return fmt.Errorf("'%s' rpc method failed: %v", method, err)
+		}
+		return nil
+	}
+
+	// rTorrent does not move data by itself: stop the torrent, move the data
+	// with a remote command, point the torrent at its new home and restart it
+	if err = call("d.stop", torrent.Hash); err != nil {
+		return
+	}
+	// Whatever happens next, the torrent must not be left stopped
+	defer func() {
+		if e := call("d.start", torrent.Hash); e != nil {
+			if err == nil {
+				err = e
+			} else {
+				err = fmt.Errorf("%v, then %v", err, e)
+			}
+		}
+	}()
+	if err = call("d.close", torrent.Hash); err != nil {
+		return
+	}
+	if err = call("execute.throw", "", "mkdir", "-p", remoteSharePath); err != nil {
+		return
+	}
+	// Older data at the destination must neither be kept in place of the
+	// torrent data nor replaced
+	target := remoteSharePath + "/" + path.Base(basePath)
+	if e := call("execute.throw", "", "test", "!", "-e", target); e != nil {
+		err = fmt.Errorf("'%s' already exists or cannot be checked: %v", target, e)
+		return
+	}
+	if err = call("execute.throw", "", "mv", basePath, target); err != nil {
+		return
+	}
+	if err = call("d.directory.set", torrent.Hash, remoteSharePath); err != nil {
+		// Put the data back where the torrent still expects it
+		if e := call("execute.throw", "", "mv", target, basePath); e != nil {
+			err = fmt.Errorf("%v, then %v", err, e)
+		}
+		return
+	}
+	return
+}
+
+type rtorrentTorrent struct {
+	hash           string
+	name           string
+	directory      string
+	complete       bool
+	multiFile      bool
+	completedBytes int64
+	sizeBytes      int64
+	chunkSize      int64
+	custom1        string
+	ratio          int64
+	loadDate       int64
+	finished       int64
+	sessionFile    string
+}
+
+func newRTorrentTorrent(row interface{}) (torrent rtorrentTorrent, err error) {
+	values, _ := row.([]interface{})
+	if len(values) < 13 {
+		err = fmt.Errorf("expected 13 fields, got %d", len(values))
+		return
+	}
+	torrent.hash, _ = values[0].(string)
+	torrent.name, _ = values[1].(string)
+	torrent.directory, _ = values[2].(string)
+	complete, _ := values[3].(int64)
+	torrent.complete = complete == 1
+	multiFile, _ := values[4].(int64)
+	torrent.multiFile = multiFile == 1
+	torrent.completedBytes, _ = values[5].(int64)
+	torrent.sizeBytes, _ = values[6].(int64)
+	torrent.chunkSize, _ = values[7].(int64)
+	torrent.custom1, _ = values[8].(string)
+	torrent.ratio, _ = values[9].(int64)
+	torrent.loadDate, _ = values[10].(int64)
+	torrent.finished, _ = values[11].(int64)
+	torrent.sessionFile, _ = values[12].(string)
+	return
+}
+
+func (r *RTorrent) rpcCall(method string, params ...interface{}) (result interface{}, err error) {
+	if r.httpC == nil {
+		err = errors.New("this controller is not initialized, please use the New() function")
+		return
+	}
+
+	var payload []byte
+	if payload, err = encodeXMLRPCCall(method, params); err != nil {
+		err = fmt.Errorf("request payload XML marshalling failed: %v", err)
+		return
+	}
+
+	var req *http.Request
+	if req, err = http.NewRequest("POST", r.url, bytes.NewReader(payload)); err != nil {
+		err = fmt.Errorf("can't prepare request for '%s' method: %v", method, err)
+		return
+	}
+	req.Header.Set("Content-Type", "text/xml")
+	if r.user != "" {
+		req.SetBasicAuth(r.user, r.password)
+	}
+
+	var resp *http.Response
+	if resp, err = r.httpC.Do(req); err != nil {
+		err = fmt.Errorf("request error: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		err = fmt.Errorf("HTTP error %d: %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+		return
+	}
+
+	if result, err = decodeXMLRPCResponse(resp.Body); err != nil {
+		if _, fault := err.(*xmlrpcFault); !fault {
+			err = fmt.Errorf("can't unmarshall request answer body: %v", err)
+		}
+	}
+	return
+}
@@ -1,19 +1,70 @@
 package provider
 
+import (
+	"net/url"
+	"time"
+)
+
 type Torrent struct {
 	Id          int64
+	Hash        string
 	Name        string
 	PercentDone float64
 	Files       []TorrentFile
 	DownloadDir string
+	Labels      []string
+	Trackers    []string
+	Size        int64
+	SeedingTime time.Duration
+	Ratio       float64
+	AddedDate   time.Time
+	// MetainfoPath is the remote path of the .torrent file, when known
+	MetainfoPath string
 }
 
+// Priorities follow the Transmission scale.
+const (
+	PriorityLow    = -1
+	PriorityNormal = 0
+	PriorityHigh   = 1
+)
+
 type TorrentFile struct {
 	Name           string
 	Length         int64
 	BytesCompleted int64
+	Wanted         bool
+	Priority       int
+}
+
+// IsCompleted tells whether all the wanted files are downloaded, unwanted
+// ones never being.
+func (t Torrent) IsCompleted() bool {
+	wanted := false
+	for _, file := range t.Files {
+		if file.Wanted {
+			wanted = true
+			if !file.IsCompleted() {
+				return false
+			}
+		}
+	}
+	if !wanted {
+		return t.PercentDone == 1
+	}
+	return true
 }
 
 func (t TorrentFile) IsCompleted() bool {
 	return t.Length == t.BytesCompleted
 }
+
+// trackerHost reduces an announce URL to its host, which is what people know
+// a tracker by.
+func trackerHost(announce string) string {
+	u, err := url.Parse(announce)
+	if err != nil || u.Hostname() == "" {
+		return announce
+	}
+	return u.Hostname()
+}
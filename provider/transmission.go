@@ -39,10 +39,19 @@ func (t *Transmission) GetTorrents() (torrents []Torrent, err error) {
 	if err = t.rpcCall("torrent-get", torrentGetParams{
 		Fields: []string{
 			"id",
+			"hashString",
 			"name",
 			"percentDone",
 			"downloadDir",
 			"files",
+			"fileStats",
+			"labels",
+			"trackers",
+			"totalSize",
+			"secondsSeeding",
+			"uploadRatio",
+			"addedDate",
+			"torrentFile",
 		},
 		IDs: nil,
 	}, &result); err != nil {
@@ -59,15 +68,34 @@ func (t *Transmission) GetTorrents() (torrents []Torrent, err error) {
 				Name:           file.Name,
 				Length:         file.Length,
 				BytesCompleted: file.BytesCompleted,
+				Wanted:         true,
+				Priority:       PriorityNormal,
 			}
+			if j < len(torrent.FileStats) {
+				files[j].Wanted = torrent.FileStats[j].Wanted
+				files[j].Priority = torrent.FileStats[j].Priority
+			}
+		}
+
+		var trackers = make([]string, len(torrent.Trackers))
+		for j, tracker := range torrent.Trackers {
+			trackers[j] = trackerHost(tracker.Announce)
 		}
 
 		torrents[i] = Torrent{
-			Id:          *torrent.ID,
-			Name:        *torrent.Name,
-			PercentDone: *torrent.PercentDone,
-			Files:       files,
-			DownloadDir: *torrent.DownloadDir,
+			Id:           *torrent.ID,
+			Hash:         *torrent.HashString,
+			Name:         *torrent.Name,
+			PercentDone:  *torrent.PercentDone,
+			Files:        files,
+			DownloadDir:  *torrent.DownloadDir,
+			Labels:       torrent.Labels,
+			Trackers:     trackers,
+			Size:         torrent.TotalSize,
+			SeedingTime:  time.Duration(torrent.SecondsSeeding) * time.Second,
+			Ratio:        torrent.UploadRatio,
+			AddedDate:    time.Unix(torrent.AddedDate, 0),
+			MetainfoPath: torrent.TorrentFile,
 		}
 	}
 	return
@@ -94,11 +122,20 @@ type torrentGetResults struct {
 }
 
 type torrent struct {
-	DownloadDir *string        `json:"downloadDir"`
-	Files       []*torrentFile `json:"files"`
-	ID          *int64         `json:"id"`
-	PercentDone *float64       `json:"percentDone"`
-	Name        *string        `json:"name"`
+	DownloadDir    *string            `json:"downloadDir"`
+	Files          []*torrentFile     `json:"files"`
+	FileStats      []*torrentFileStat `json:"fileStats"`
+	ID             *int64             `json:"id"`
+	HashString     *string            `json:"hashString"`
+	PercentDone    *float64           `json:"percentDone"`
+	Name           *string            `json:"name"`
+	Labels         []string           `json:"labels"`
+	Trackers       []*torrentTracker  `json:"trackers"`
+	TotalSize      int64              `json:"totalSize"`
+	SecondsSeeding int64              `json:"secondsSeeding"`
+	UploadRatio    float64            `json:"uploadRatio"`
+	AddedDate      int64              `json:"addedDate"`
+	TorrentFile    string             `json:"torrentFile"`
 }
 
 type torrentFile struct {
@@ -107,6 +144,16 @@ type torrentFile struct {
 	Name           string `json:"name"`
 }
 
+type torrentFileStat struct {
+	BytesCompleted int64 `json:"bytesCompleted"`
+	Wanted         bool  `json:"wanted"`
+	Priority       int   `json:"priority"`
+}
+
+type torrentTracker struct {
+	Announce string `json:"announce"`
+}
+
 type torrentSetLocationPayload struct {
 	IDs      []int64 `json:"ids"`
 	Location string  `json:"location"`
@@ -0,0 +1,179 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+type xmlrpcFault struct {
+	Code   int64
+	String string
+}
+
+func (f *xmlrpcFault) Error() string {
+	return fmt.Sprintf("xml-rpc fault %d: %s", f.Code, f.String)
+}
+
+func encodeXMLRPCCall(method string, params []interface{}) (payload []byte, err error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.WriteString("<methodCall><methodName>")
+	if err = xml.EscapeText(&buf, []byte(method)); err != nil {
+		return
+	}
+	buf.WriteString("</methodName><params>")
+	for _, param := range params {
+		buf.WriteString("<param>")
+		if err = encodeXMLRPCValue(&buf, param); err != nil {
+			return
+		}
+		buf.WriteString("</param>")
+	}
+	buf.WriteString("</params></methodCall>")
+	payload = buf.Bytes()
+	return
+}
+
+func encodeXMLRPCValue(buf *bytes.Buffer, value interface{}) (err error) {
+	buf.WriteString("<value>")
+	switch v := value.(type) {
+	case string:
+		buf.WriteString("<string>")
+		err = xml.EscapeText(buf, []byte(v))
+		buf.WriteString("</string>")
+	case int:
+		buf.WriteString("<i8>" + strconv.Itoa(v) + "</i8>")
+	case int64:
+		buf.WriteString("<i8>" + strconv.FormatInt(v, 10) + "</i8>")
+	case bool:
+		if v {
+			buf.WriteString("<boolean>1</boolean>")
+		} else {
+			buf.WriteString("<boolean>0</boolean>")
+		}
+	case float64:
+		buf.WriteString("<double>" + strconv.FormatFloat(v, 'f', -1, 64) + "</double>")
+	case []string:
+		buf.WriteString("<array><data>")
+		for _, item := range v {
+			if err = encodeXMLRPCValue(buf, item); err != nil {
+				return
+			}
+		}
+		buf.WriteString("</data></array>")
+	case []interface{}:
+		buf.WriteString("<array><data>")
+		for _, item := range v {
+			if err = encodeXMLRPCValue(buf, item); err != nil {
+				return
+			}
+		}
+		buf.WriteString("</data></array>")
+	default:
+		err = fmt.Errorf("unsupported xml-rpc parameter type %T", value)
+	}
+	buf.WriteString("</value>")
+	return
+}
+
+type xmlrpcResponse struct {
+	Params []xmlrpcValue `xml:"params>param>value"`
+	Fault  *xmlrpcValue  `xml:"fault>value"`
+}
+
+type xmlrpcValue struct {
+	String  *string       `xml:"string"`
+	Int     *string       `xml:"int"`
+	I4      *string       `xml:"i4"`
+	I8      *string       `xml:"i8"`
+	Boolean *string       `xml:"boolean"`
+	Double  *string       `xml:"double"`
+	Base64  *string       `xml:"base64"`
+	Array   *xmlrpcArray  `xml:"array"`
+	Struct  *xmlrpcStruct `xml:"struct"`
+	Text    string        `xml:",chardata"`
+}
+
+type xmlrpcArray struct {
+	Values []xmlrpcValue `xml:"data>value"`
+}
+
+type xmlrpcStruct struct {
+	Members []xmlrpcMember `xml:"member"`
+}
+
+type xmlrpcMember struct {
+	Name  string      `xml:"name"`
+	Value xmlrpcValue `xml:"value"`
+}
+
+// decodeXMLRPCResponse returns the first value of a method response as plain
+// Go values: string, int64, bool, float64, []byte, []interface{} and
+// map[string]interface{}.
+func decodeXMLRPCResponse(r io.Reader) (result interface{}, err error) {
+	var response xmlrpcResponse
+	if err = xml.NewDecoder(r).Decode(&response); err != nil {
+		return
+	}
+	if response.Fault != nil {
+		var fault interface{}
+		if fault, err = response.Fault.decode(); err != nil {
+			return
+		}
+		members, _ := fault.(map[string]interface{})
+		code, _ := members["faultCode"].(int64)
+		message, _ := members["faultString"].(string)
+		err = &xmlrpcFault{Code: code, String: message}
+		return
+	}
+	if len(response.Params) == 0 {
+		err = errors.New("xml-rpc response has no value")
+		return
+	}
+	return response.Params[0].decode()
+}
+
+func (v xmlrpcValue) decode() (result interface{}, err error) {
+	switch {
+	case v.String != nil:
+		result = *v.String
+	case v.Int != nil:
+		result, err = strconv.ParseInt(strings.TrimSpace(*v.Int), 10, 64)
+	case v.I4 != nil:
+		result, err = strconv.ParseInt(strings.TrimSpace(*v.I4), 10, 64)
+	case v.I8 != nil:
+		result, err = strconv.ParseInt(strings.TrimSpace(*v.I8), 10, 64)
+	case v.Boolean != nil:
+		result = strings.TrimSpace(*v.Boolean) == "1"
+	case v.Double != nil:
+		result, err = strconv.ParseFloat(strings.TrimSpace(*v.Double), 64)
+	case v.Base64 != nil:
+		result, err = base64.StdEncoding.DecodeString(strings.TrimSpace(*v.Base64))
+	case v.Array != nil:
+		items := make([]interface{}, len(v.Array.Values))
+		for i, item := range v.Array.Values {
+			if items[i], err = item.decode(); err != nil {
+				return
+			}
+		}
+		result = items
+	case v.Struct != nil:
+		members := make(map[string]interface{}, len(v.Struct.Members))
+		for _, member := range v.Struct.Members {
+			if members[member.Name], err = member.Value.decode(); err != nil {
+				return
+			}
+		}
+		result = members
+	default:
+		// A value without type element is a string
+		result = v.Text
+	}
+	return
+}
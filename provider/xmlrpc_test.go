@@ -0,0 +1,97 @@
+package provider
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEncodeXMLRPCCall(t *testing.T) {
+	payload, err := encodeXMLRPCCall("d.multicall2", []interface{}{"", "main", 42, int64(-1), true, 0.5, []string{"a<b"}, []interface{}{false}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+		"<methodCall><methodName>d.multicall2</methodName><params>" +
+		"<param><value><string></string></value></param>" +
+		"<param><value><string>main</string></value></param>" +
+		"<param><value><i8>42</i8></value></param>" +
+		"<param><value><i8>-1</i8></value></param>" +
+		"<param><value><boolean>1</boolean></value></param>" +
+		"<param><value><double>0.5</double></value></param>" +
+		"<param><value><array><data><value><string>a&lt;b</string></value></data></array></value></param>" +
+		"<param><value><array><data><value><boolean>0</boolean></value></data></array></value></param>" +
+		"</params></methodCall>"
+	if string(payload) != want {
+		t.Errorf("payload = %s, want %s", payload, want)
+	}
+
+	if _, err := encodeXMLRPCCall("system.listMethods", []interface{}{struct{}{}}); err == nil {
+		t.Error("an unsupported parameter is encoded")
+	}
+}
+
+func TestDecodeXMLRPCResponse(t *testing.T) {
+	result, err := decodeXMLRPCResponse(strings.NewReader(`<?xml version="1.0"?>
+<methodResponse><params><param><value><array><data>
+	<value><string>ABCD</string></value>
+	<value>Movie &amp; Co</value>
+	<value><i4>-3</i4></value>
+	<value><int> 7 </int></value>
+	<value><i8>5368709120</i8></value>
+	<value><boolean>1</boolean></value>
+	<value><double>1.25</double></value>
+	<value><base64>ZDQ6aW5mb2Vl</base64></value>
+	<value><struct>
+		<member><name>path</name><value><string>/complete</string></value></member>
+		<member><name>files</name><value><array><data></data></array></value></member>
+	</struct></value>
+</data></array></value></param></params></methodResponse>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{
+		"ABCD",
+		"Movie & Co",
+		int64(-3),
+		int64(7),
+		int64(5368709120),
+		true,
+		1.25,
+		[]byte("d4:infoee"),
+		map[string]interface{}{"path": "/complete", "files": []interface{}{}},
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("result = %#v, want %#v", result, want)
+	}
+}
+
+func TestDecodeXMLRPCFault(t *testing.T) {
+	_, err := decodeXMLRPCResponse(strings.NewReader(`<?xml version="1.0"?>
+<methodResponse><fault><value><struct>
+	<member><name>faultCode</name><value><i4>-501</i4></value></member>
+	<member><name>faultString</name><value><string>Could not find info-hash.</string></value></member>
+</struct></value></fault></methodResponse>`))
+	var fault *xmlrpcFault
+	if !errors.As(err, &fault) {
+		t.Fatalf("error = %v, want a fault", err)
+	}
+	if fault.Code != -501 || fault.String != "Could not find info-hash." {
+		t.Errorf("fault = %+v", fault)
+	}
+}
+
+func TestDecodeInvalidXMLRPCResponse(t *testing.T) {
+	for _, response := range []string{
+		"",
+		"<methodResponse><params></params></methodResponse>",
+		"<methodResponse><params><param><value><i8>big</i8></value></param></params></methodResponse>",
+		"<methodResponse><params><param><value><base64>!!</base64></value></param></params></methodResponse>",
+		"<methodResponse><params><param><value><array><data><value><double>x</double></value></data></array></value></param></params></methodResponse>",
+	} {
+		if _, err := decodeXMLRPCResponse(strings.NewReader(response)); err == nil {
+			t.Errorf("%q is accepted", response)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package state
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	StatusDownloading = "downloading"
+	StatusDownloaded  = "downloaded"
+	StatusMoved       = "moved"
+	StatusSynced      = "synced"
+)
+
+type Torrent struct {
+	Hash      string           `json:"hash"`
+	Name      string           `json:"name"`
+	Folder    string           `json:"folder"`
+	Status    string           `json:"status"`
+	Files     map[string]*File `json:"files"`
+	UpdatedAt time.Time        `json:"updatedAt"`
+}
+
+type File struct {
+	Status    string    `json:"status"`
+	Bytes     int64     `json:"bytes"`
+	Checksum  string    `json:"checksum,omitempty"`
+	ModTime   time.Time `json:"modTime,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Store keeps track of the synchronisation of each torrent, keyed by hash.
+// Every change is written to disk right away so that it survives restarts;
+// a store without path only lives in memory.
+type Store struct {
+	path     string
+	torrents map[string]*Torrent
+	access   sync.Mutex
+}
+
+func Open(path string) (store *Store, err error) {
+	store = &Store{
+		path:     path,
+		torrents: map[string]*Torrent{},
+	}
+	if path == "" {
+		return
+	}
+
+	bytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(bytes, &store.torrents)
+	return
+}
+
+func (s *Store) Path() string {
+	return s.path
+}
+
+func (s *Store) IsPersistent() bool {
+	return s.path != ""
+}
+
+func (s *Store) Torrent(hash string) (torrent Torrent, ok bool) {
+	defer s.access.Unlock()
+	s.access.Lock()
+	t, ok := s.torrents[hash]
+	if ok {
+		torrent = t.copy()
+	}
+	return
+}
+
+func (s *Store) Torrents() (torrents []Torrent) {
+	defer s.access.Unlock()
+	s.access.Lock()
+	for _, t := range s.torrents {
+		torrents = append(torrents, t.copy())
+	}
+	sort.Slice(torrents, func(i, j int) bool {
+		return torrents[i].UpdatedAt.Before(torrents[j].UpdatedAt)
+	})
+	return
+}
+
+func (s *Store) SetTorrentStatus(hash, name, folder, status string) error {
+	defer s.access.Unlock()
+	s.access.Lock()
+	t := s.torrent(hash)
+	t.Name = name
+	t.Folder = folder
+	t.Status = status
+	t.UpdatedAt = time.Now()
+	return s.save()
+}
+
+func (s *Store) SetFile(hash, name string, file File) error {
+	defer s.access.Unlock()
+	s.access.Lock()
+	t := s.torrent(hash)
+	file.UpdatedAt = time.Now()
+	t.Files[name] = &file
+	t.UpdatedAt = file.UpdatedAt
+	return s.save()
+}
+
+func (s *Store) Delete(hash string) error {
+	defer s.access.Unlock()
+	s.access.Lock()
+	delete(s.torrents, hash)
+	return s.save()
+}
+
+func (s *Store) torrent(hash string) *Torrent {
+	t, ok := s.torrents[hash]
+	if !ok {
+		t = &Torrent{
+			Hash:  hash,
+			Files: map[string]*File{},
+		}
+		s.torrents[hash] = t
+	}
+	return t
+}
+
+// save replaces the state file atomically so that a crash never leaves a
+// truncated file behind.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+	bytes, err := json.MarshalIndent(s.torrents, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(bytes); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func (t *Torrent) copy() Torrent {
+	c := *t
+	c.Files = make(map[string]*File, len(t.Files))
+	for name, file := range t.Files {
+		f := *file
+		c.Files[name] = &f
+	}
+	return c
+}
@@ -0,0 +1,188 @@
+package task
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"seedbox-sync/downloader"
+	"seedbox-sync/model"
+	"seedbox-sync/notifier"
+	"seedbox-sync/provider"
+	"seedbox-sync/state"
+	"sort"
+	"strings"
+)
+
+// Clean removes from the temp paths what no torrent will ever need again.
+type Clean struct {
+	sync *Sync
+}
+
+func NewClean(folders []model.Folder, transfer model.TransferConfiguration, provider provider.Provider, pool *downloader.Pool, store *state.Store, n notifier.Notifier, output io.Writer) *Clean {
+	return &Clean{
+		sync: NewSync(folders, transfer, provider, pool, store, n, output),
+	}
+}
+
+func (c *Clean) Execute() {
+	defer c.sync.pool.Close()
+
+	torrents, err := c.sync.provider.GetTorrents()
+	if err != nil {
+		fmt.Fprintln(c.sync.output, "unable to retrieve the torrents:", err)
+		return
+	}
+	c.sync.clean(torrents, true)
+}
+
+// clean deletes the files left in the temp paths by the synchronisation
+// which belong to no torrent still waiting in a remote complete path, then
+// the empty directories. Only part files, their resume files and files
+// recorded in the state are ever deleted. Torrents already finalized keep
+// nothing either, unless their folder transfers copies of the files, kept as
+// long as the torrent. Temp paths linked to from a post processing path hold
+// the only copy of the files and are left alone. Unless all is set, only the
+// folders asking for it are cleaned.
+func (s *Sync) clean(torrents []provider.Torrent, all bool) {
+	live := map[string]bool{}
+	owned := map[string]bool{}
+	var folders []model.Folder
+	seen := map[string]bool{}
+	linked := map[string]bool{}
+	for _, folder := range s.folders {
+		for _, torrent := range torrents {
+			kept := keepsTemp(folder.TransferMode) && torrent.DownloadDir == folder.RemoteSharePath
+			if torrent.DownloadDir != folder.RemoteCompletePath && !kept {
+				continue
+			}
+			record, _ := s.store.Torrent(torrentKey(torrent))
+			if (record.Status == state.StatusMoved || record.Status == state.StatusSynced) && !keepsTemp(folder.TransferMode) {
+				continue
+			}
+			for _, file := range torrent.Files {
+				partFile := filepath.Clean(s.partFile(folder, file.Name))
+				live[filepath.Clean(folder.LocalTempPath+"/"+file.Name)] = true
+				live[partFile] = true
+				live[partFile+resumeSuffix] = true
+			}
+		}
+		for _, record := range s.store.Torrents() {
+			if record.Folder != folder.RemoteCompletePath {
+				continue
+			}
+			for name := range record.Files {
+				owned[filepath.Clean(folder.LocalTempPath+"/"+name)] = true
+			}
+		}
+		// Folders may share their temp path
+		tempPath := filepath.Clean(folder.LocalTempPath)
+		if folder.TransferMode == transferSymlink {
+			linked[tempPath] = true
+		}
+		if (all || folder.Clean) && !seen[tempPath] {
+			seen[tempPath] = true
+			folders = append(folders, folder)
+		}
+	}
+
+	for _, folder := range folders {
+		if err := s.checkCleanable(folder.LocalTempPath); err != nil {
+			fmt.Fprintln(s.output, "unable to clean", folder.LocalTempPath, ":", err)
+			return
+		}
+	}
+	for _, folder := range folders {
+		if linked[filepath.Clean(folder.LocalTempPath)] {
+			continue
+		}
+		root := filepath.Clean(folder.LocalTempPath)
+		files, reclaimed, err := s.cleanPath(root, func(name string) bool {
+			return !live[name] && (owned[name] || s.isPartFile(root, name))
+		})
+		if err != nil {
+			fmt.Fprintln(s.output, "unable to clean", folder.LocalTempPath, ":", err)
+		}
+		s.notifier.Reclaimed(folder, files, reclaimed)
+	}
+}
+
+// checkCleanable refuses to clean a temp path holding a post processing path
+// or the state file.
+func (s *Sync) checkCleanable(tempPath string) error {
+	for _, folder := range s.folders {
+		if isInside(folder.LocalPostProcessingPath, tempPath) {
+			return fmt.Errorf("it holds the post processing path %s", folder.LocalPostProcessingPath)
+		}
+	}
+	if path := s.store.Path(); path != "" && isInside(path, tempPath) {
+		return fmt.Errorf("it holds the state file %s", path)
+	}
+	return nil
+}
+
+// isInside tells whether name is dir or lies below it.
+func isInside(name string, dir string) bool {
+	name, err := filepath.Abs(name)
+	if err != nil {
+		return true
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return true
+	}
+	rel, err := filepath.Rel(dir, name)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// isPartFile tells whether a file of a temp path is a part file, or its
+// resume file.
+func (s *Sync) isPartFile(root string, name string) bool {
+	rel := strings.TrimSuffix(strings.TrimPrefix(name, root+"/"), resumeSuffix)
+	suffix := s.transfer.PartSuffix
+	if s.transfer.PartDir != "" {
+		return strings.HasPrefix(rel, s.transfer.PartDir+"/") && strings.HasSuffix(rel, suffix)
+	}
+	if suffix == "" {
+		suffix = defaultPartSuffix
+	}
+	return strings.HasSuffix(rel, suffix)
+}
+
+func (s *Sync) cleanPath(root string, deletable func(string) bool) (files int, reclaimed int64, err error) {
+	var dirs []string
+	err = filepath.Walk(root, func(name string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if name != root {
+				dirs = append(dirs, name)
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || !deletable(name) {
+			return nil
+		}
+		if e := os.Remove(name); e != nil {
+			fmt.Fprintln(s.output, "unable to delete", name, ":", e)
+			return nil
+		}
+		files++
+		reclaimed += info.Size()
+		return nil
+	})
+
+	// Deepest directories first so that parents become empty in turn
+	sort.Slice(dirs, func(i, j int) bool {
+		return len(dirs[i]) > len(dirs[j])
+	})
+	for _, dir := range dirs {
+		// Fails on purpose for directories which are not empty
+		_ = os.Remove(dir)
+	}
+	return
+}
@@ -0,0 +1,69 @@
+package task
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Policies applied when a file already exists in the post processing path.
+const (
+	conflictOverwrite  = "overwrite"
+	conflictSkip       = "skip"
+	conflictRename     = "rename"
+	conflictKeepLarger = "keepLarger"
+	conflictFail       = "fail"
+)
+
+func checkConflictPolicy(policy string) error {
+	switch policy {
+	case "", conflictOverwrite, conflictSkip, conflictRename, conflictKeepLarger, conflictFail:
+		return nil
+	}
+	return fmt.Errorf("unknown conflict policy '%s'", policy)
+}
+
+// resolveConflict decides what to do with a file of the given size about to
+// be moved onto newName, without changing anything: no conflict gives an
+// empty decision, otherwise it is one of overwrite, skip, rename (to dest) or
+// fail.
+func resolveConflict(policy string, size int64, newName string) (decision string, dest string, err error) {
+	existing, err := os.Stat(newName)
+	if os.IsNotExist(err) {
+		return "", newName, nil
+	}
+	if err != nil {
+		return
+	}
+
+	switch policy {
+	case "", conflictOverwrite:
+		return conflictOverwrite, newName, nil
+	case conflictSkip, conflictFail:
+		return policy, newName, nil
+	case conflictRename:
+		dest, err = freeName(newName)
+		return conflictRename, dest, err
+	case conflictKeepLarger:
+		if existing.Size() >= size {
+			return conflictSkip, newName, nil
+		}
+		return conflictOverwrite, newName, nil
+	}
+	return "", "", checkConflictPolicy(policy)
+}
+
+// freeName appends the first free " (n)" suffix to the base of a name.
+func freeName(name string) (string, error) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, i, ext)
+		if _, err := os.Stat(candidate); os.IsNotExist(err) {
+			return candidate, nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package task
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveConflict(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "a.mkv")
+	writeTestFile(t, existing, make([]byte, 10))
+	writeTestFile(t, filepath.Join(dir, "a (1).mkv"), nil)
+	missing := filepath.Join(dir, "b.mkv")
+
+	tests := []struct {
+		policy   string
+		size     int64
+		newName  string
+		decision string
+		dest     string
+	}{
+		{conflictFail, 10, missing, "", missing},
+		{"", 10, existing, conflictOverwrite, existing},
+		{conflictOverwrite, 10, existing, conflictOverwrite, existing},
+		{conflictSkip, 10, existing, conflictSkip, existing},
+		{conflictFail, 10, existing, conflictFail, existing},
+		{conflictRename, 10, existing, conflictRename, filepath.Join(dir, "a (2).mkv")},
+		{conflictKeepLarger, 10, existing, conflictSkip, existing},
+		{conflictKeepLarger, 11, existing, conflictOverwrite, existing},
+	}
+	for _, test := range tests {
+		decision, dest, err := resolveConflict(test.policy, test.size, test.newName)
+		if err != nil {
+			t.Errorf("%s of %d bytes onto %s: %v", test.policy, test.size, test.newName, err)
+			continue
+		}
+		if decision != test.decision || dest != test.dest {
+			t.Errorf("%s of %d bytes onto %s = %s %s, want %s %s", test.policy, test.size, test.newName, decision, dest, test.decision, test.dest)
+		}
+	}
+
+	if _, _, err := resolveConflict("merge", 10, existing); err == nil {
+		t.Error("an unknown policy is accepted")
+	}
+	if err := checkConflictPolicy("merge"); err == nil {
+		t.Error("an unknown policy passes the check")
+	}
+}
+
+func TestFreeName(t *testing.T) {
+	dir := t.TempDir()
+	name, err := freeName(filepath.Join(dir, "Movie", "archive.tar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "Movie", "archive (1).tar"); name != want {
+		t.Errorf("free name = %s, want %s", name, want)
+	}
+
+	writeTestFile(t, filepath.Join(dir, "README (1)"), nil)
+	name, err = freeName(filepath.Join(dir, "README"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "README (2)"); name != want {
+		t.Errorf("free name = %s, want %s", name, want)
+	}
+}
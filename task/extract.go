@@ -0,0 +1,313 @@
+package task
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"seedbox-sync/model"
+	"seedbox-sync/provider"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bodgit/sevenzip"
+	"github.com/nwaples/rardecode"
+)
+
+const (
+	formatRar      = "rar"
+	formatZip      = "zip"
+	formatSevenZip = "7z"
+)
+
+var (
+	rarPartPattern        = regexp.MustCompile(`(?i)\.part(\d+)\.rar$`)
+	rarVolumePattern      = regexp.MustCompile(`(?i)\.(rar|r\d{2,3})$`)
+	sevenZipVolumePattern = regexp.MustCompile(`(?i)\.7z\.(\d+)$`)
+)
+
+// archive is the first volume of an archive, the others being found when
+// extracting it.
+type archive struct {
+	name   string
+	format string
+}
+
+// findArchives returns the archives among the files of a torrent. Rar sets
+// are either named .part01.rar, .part02.rar... or .rar, .r00, .r01...
+func findArchives(files []provider.TorrentFile) (archives []archive) {
+	for _, file := range files {
+		lower := strings.ToLower(file.Name)
+		if m := rarPartPattern.FindStringSubmatch(lower); m != nil {
+			if n, _ := strconv.Atoi(m[1]); n == 1 {
+				archives = append(archives, archive{name: file.Name, format: formatRar})
+			}
+			continue
+		}
+		if m := sevenZipVolumePattern.FindStringSubmatch(lower); m != nil {
+			if n, _ := strconv.Atoi(m[1]); n == 1 {
+				archives = append(archives, archive{name: file.Name, format: formatSevenZip})
+			}
+			continue
+		}
+		switch path.Ext(lower) {
+		case ".rar":
+			archives = append(archives, archive{name: file.Name, format: formatRar})
+		case ".zip":
+			archives = append(archives, archive{name: file.Name, format: formatZip})
+		case ".7z":
+			archives = append(archives, archive{name: file.Name, format: formatSevenZip})
+		}
+	}
+	return
+}
+
+// volumeSet returns the files of a torrent holding the volumes of an archive.
+func volumeSet(a archive, files []provider.TorrentFile) (names []string) {
+	var pattern *regexp.Regexp
+	switch {
+	case rarPartPattern.MatchString(a.name):
+		pattern = rarPartPattern
+	case sevenZipVolumePattern.MatchString(a.name):
+		pattern = sevenZipVolumePattern
+	case a.format == formatRar:
+		pattern = rarVolumePattern
+	default:
+		return []string{a.name}
+	}
+	stem := a.name[:pattern.FindStringIndex(a.name)[0]]
+	for _, file := range files {
+		if loc := pattern.FindStringIndex(file.Name); loc != nil && strings.EqualFold(file.Name[:loc[0]], stem) {
+			names = append(names, file.Name)
+		}
+	}
+	return
+}
+
+// extractArchives unpacks the archives of a torrent next to where moveFiles
+// put them in the post processing path, then deletes them if asked to once
+// the torrent is finalized. Archives which were not moved are left alone.
+func (s *Sync) extractArchives(tx *transaction, folder model.Folder, filter folderFilter, torrent provider.Torrent, moved map[string]string) {
+	files := filter.selectedFiles(torrent)
+	for _, a := range findArchives(files) {
+		name, ok := moved[a.name]
+		if !ok {
+			fmt.Fprintln(s.output, "not extracting", a.name, ": it was not moved")
+			continue
+		}
+		var volumes []string
+		var extracted int
+		err := checkVolumes(folder, a, files, moved)
+		if err == nil {
+			volumes, extracted, err = s.extractArchive(tx, folder, a, name)
+		}
+		s.notifier.Extracted(torrent, a.name, extracted, err == nil)
+		if err != nil {
+			fmt.Fprintln(s.output, "unable to extract", a.name, ":", err)
+			continue
+		}
+		if !folder.Extract.DeleteArchives {
+			continue
+		}
+		for _, volume := range volumes {
+			tx.discard(volume)
+		}
+	}
+}
+
+// checkVolumes makes sure every volume of an archive was moved under its own
+// name, the volumes of a set being found by their names.
+func checkVolumes(folder model.Folder, a archive, files []provider.TorrentFile, moved map[string]string) error {
+	names := volumeSet(a, files)
+	for _, name := range names {
+		dest, ok := moved[name]
+		if !ok {
+			return fmt.Errorf("its volume %s was not moved", name)
+		}
+		if len(names) > 1 && dest != folder.LocalPostProcessingPath+"/"+name {
+			return fmt.Errorf("its volume %s was renamed to %s", name, dest)
+		}
+	}
+	return nil
+}
+
+func (s *Sync) extractArchive(tx *transaction, folder model.Folder, a archive, name string) (volumes []string, files int, err error) {
+	x := extraction{
+		sync:     s,
+		tx:       tx,
+		dir:      filepath.Dir(name),
+		name:     a.name,
+		perms:    s.permissions(folder),
+		conflict: folder.Conflict,
+	}
+
+	switch a.format {
+	case formatRar:
+		var r *rardecode.ReadCloser
+		if r, err = rardecode.OpenReader(name, ""); err != nil {
+			return
+		}
+		defer r.Close()
+		for {
+			var header *rardecode.FileHeader
+			if header, err = r.Next(); err == io.EOF {
+				err = nil
+				break
+			}
+			if err != nil {
+				return
+			}
+			if !header.IsDir && !header.Mode().IsRegular() {
+				continue
+			}
+			if err = x.entry(header.Name, header.IsDir, &r.Reader, header.UnPackedSize, header.ModificationTime); err != nil {
+				return
+			}
+		}
+		volumes = r.Volumes()
+
+	case formatZip:
+		var r *zip.ReadCloser
+		if r, err = zip.OpenReader(name); err != nil {
+			return
+		}
+		defer r.Close()
+		for _, f := range r.File {
+			if err = x.open(f.Name, f.FileInfo(), f.Open); err != nil {
+				return
+			}
+		}
+		volumes = []string{name}
+
+	case formatSevenZip:
+		var r *sevenzip.ReadCloser
+		if r, err = sevenzip.OpenReader(name); err != nil {
+			return
+		}
+		defer r.Close()
+		for _, f := range r.File {
+			if err = x.open(f.Name, f.FileInfo(), f.Open); err != nil {
+				return
+			}
+		}
+		volumes = sevenZipVolumes(name)
+	}
+	return volumes, x.files, nil
+}
+
+// sevenZipVolumes returns the volumes of a 7z archive, split ones being
+// named .7z.001, .7z.002...
+func sevenZipVolumes(name string) (volumes []string) {
+	if !sevenZipVolumePattern.MatchString(name) {
+		return []string{name}
+	}
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	for i := 1; ; i++ {
+		volume := fmt.Sprintf("%s.%03d", base, i)
+		if _, err := os.Stat(volume); err != nil {
+			return
+		}
+		volumes = append(volumes, volume)
+	}
+}
+
+// extraction writes the entries of an archive in its directory, with the
+// conflict policy and the permissions of its folder, as part of the
+// finalization of its torrent. Links are not extracted, they could point
+// anywhere.
+type extraction struct {
+	sync     *Sync
+	tx       *transaction
+	dir      string
+	name     string
+	perms    permissions
+	conflict string
+	files    int
+}
+
+func (x *extraction) open(name string, fi os.FileInfo, open func() (io.ReadCloser, error)) error {
+	if fi.IsDir() {
+		return x.entry(name, true, nil, 0, fi.ModTime())
+	}
+	if !fi.Mode().IsRegular() {
+		return nil
+	}
+	reader, err := open()
+	if err != nil {
+		return err
+	}
+	err = x.entry(name, false, reader, fi.Size(), fi.ModTime())
+	if closeErr := reader.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (x *extraction) entry(name string, isDir bool, reader io.Reader, size int64, modTime time.Time) (err error) {
+	// Entries must not escape the directory of the archive
+	clean := path.Clean("/" + strings.Replace(name, "\\", "/", -1))
+	if clean == "/" {
+		return nil
+	}
+	dest := x.dir + clean
+	if isDir {
+		return x.perms.mkdirAll(dest)
+	}
+
+	file := provider.TorrentFile{Name: x.name + ":" + clean[1:], Length: size}
+	decision, dest, err := resolveConflict(x.conflict, size, dest)
+	if err != nil {
+		return
+	}
+	if decision != "" {
+		x.sync.notifier.Conflict(file, dest, decision)
+	}
+	switch decision {
+	case conflictSkip:
+		return nil
+	case conflictFail:
+		return fmt.Errorf("%s already exists", dest)
+	}
+
+	if err = x.perms.mkdirAll(filepath.Dir(dest)); err != nil {
+		return
+	}
+	x.sync.notifier.StartFile(file)
+	defer func() {
+		x.sync.notifier.EndFile(file, err == nil)
+	}()
+
+	// Existing files are only replaced once the entry is complete
+	partFile := dest + defaultPartSuffix
+	open, err := os.OpenFile(partFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, defaultFileMode)
+	if err != nil {
+		return
+	}
+	_, err = io.Copy(open, &ProxyReader{reader: reader, progress: newFileProgress(file, 0, x.sync.notifier)})
+	if closeErr := open.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = x.perms.applyFile(partFile)
+	}
+	if err == nil && !modTime.IsZero() {
+		err = os.Chtimes(partFile, time.Now(), modTime)
+	}
+	if err == nil && decision == conflictOverwrite {
+		err = x.tx.replace(dest)
+	}
+	if err == nil {
+		err = os.Rename(partFile, dest)
+	}
+	if err != nil {
+		os.Remove(partFile)
+		return
+	}
+	x.tx.create(dest)
+	x.files++
+	return
+}
@@ -0,0 +1,279 @@
+package task
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"seedbox-sync/model"
+	"seedbox-sync/provider"
+	"strings"
+	"time"
+)
+
+const (
+	excludedKeep   = "keep"
+	excludedDelete = "delete"
+)
+
+// folderFilter selects the torrents synchronised by a folder: completed ones
+// in its remote path, or all of them when files are synchronised early,
+// matching one of the include rules if any and none of the exclude rules.
+type folderFilter struct {
+	remoteCompletePath string
+	early              bool
+	include            []torrentRule
+	exclude            []torrentRule
+	files              fileFilter
+}
+
+type fileFilter struct {
+	include        []string
+	exclude        []string
+	extensions     []string
+	minSize        int64
+	deleteExcluded bool
+}
+
+type torrentRule struct {
+	name           *regexp.Regexp
+	labels         []string
+	trackers       []string
+	minSize        int64
+	maxSize        int64
+	minSeedingTime time.Duration
+	minRatio       float64
+	addedBefore    time.Time
+	addedAfter     time.Time
+}
+
+func newFolderFilter(folder model.Folder) (f folderFilter, err error) {
+	f.remoteCompletePath = folder.RemoteCompletePath
+	f.early = folder.SyncFilesEarly
+	if f.include, err = newTorrentRules("include", folder.Include); err != nil {
+		return
+	}
+	if f.exclude, err = newTorrentRules("exclude", folder.Exclude); err != nil {
+		return
+	}
+	if f.files, err = newFileFilter(folder.Files); err != nil {
+		return
+	}
+	if err = checkConflictPolicy(folder.Conflict); err != nil {
+		return
+	}
+	if err = checkTransferMode(folder.TransferMode); err != nil {
+		return
+	}
+	_, err = newPermissions(folder.Permissions)
+	return
+}
+
+func newFileFilter(rules model.FileRules) (f fileFilter, err error) {
+	if f.include, err = newGlobs("include", rules.Include); err != nil {
+		return
+	}
+	if f.exclude, err = newGlobs("exclude", rules.Exclude); err != nil {
+		return
+	}
+	for _, extension := range rules.ExcludeExtensions {
+		f.extensions = append(f.extensions, "."+strings.TrimPrefix(strings.ToLower(extension), "."))
+	}
+	f.minSize = rules.MinSize
+	switch rules.Excluded {
+	case "", excludedKeep:
+	case excludedDelete:
+		f.deleteExcluded = true
+	default:
+		err = fmt.Errorf("unknown excluded files policy '%s'", rules.Excluded)
+	}
+	return
+}
+
+func newGlobs(kind string, patterns []string) (globs []string, err error) {
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if _, err = path.Match(pattern, ""); err != nil {
+			err = fmt.Errorf("invalid %s file pattern '%s': %v", kind, pattern, err)
+			return
+		}
+		globs = append(globs, pattern)
+	}
+	return
+}
+
+func newTorrentRules(kind string, rules []model.TorrentRule) (compiled []torrentRule, err error) {
+	for i, rule := range rules {
+		var r torrentRule
+		if r, err = newTorrentRule(rule); err != nil {
+			err = fmt.Errorf("%s rule %d: %v", kind, i+1, err)
+			return
+		}
+		compiled = append(compiled, r)
+	}
+	return
+}
+
+func newTorrentRule(rule model.TorrentRule) (r torrentRule, err error) {
+	if rule.Name != "" {
+		if r.name, err = regexp.Compile(rule.Name); err != nil {
+			err = fmt.Errorf("invalid name: %v", err)
+			return
+		}
+	}
+	if rule.MinSeedingTime != "" {
+		if r.minSeedingTime, err = time.ParseDuration(rule.MinSeedingTime); err != nil {
+			err = fmt.Errorf("invalid minSeedingTime: %v", err)
+			return
+		}
+	}
+	if r.addedBefore, err = parseDate(rule.AddedBefore); err != nil {
+		err = fmt.Errorf("invalid addedBefore: %v", err)
+		return
+	}
+	if r.addedAfter, err = parseDate(rule.AddedAfter); err != nil {
+		err = fmt.Errorf("invalid addedAfter: %v", err)
+		return
+	}
+	r.labels = rule.Labels
+	r.trackers = rule.Trackers
+	r.minSize = rule.MinSize
+	r.maxSize = rule.MaxSize
+	r.minRatio = rule.MinRatio
+	return
+}
+
+func parseDate(value string) (date time.Time, err error) {
+	if value == "" {
+		return
+	}
+	if date, err = time.Parse(time.RFC3339, value); err == nil {
+		return
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
+
+func (f folderFilter) matches(torrent provider.Torrent) bool {
+	if torrent.DownloadDir != f.remoteCompletePath || !(f.early || torrent.IsCompleted()) {
+		return false
+	}
+	return f.matchesRules(torrent)
+}
+
+func (f folderFilter) matchesRules(torrent provider.Torrent) bool {
+	if len(f.include) > 0 && !anyRuleMatches(f.include, torrent) {
+		return false
+	}
+	return !anyRuleMatches(f.exclude, torrent)
+}
+
+// selectedFiles returns the files of a torrent to download and move.
+func (f folderFilter) selectedFiles(torrent provider.Torrent) (files []provider.TorrentFile) {
+	for _, file := range torrent.Files {
+		if file.Wanted && file.IsCompleted() && f.files.matches(file) {
+			files = append(files, file)
+		}
+	}
+	return
+}
+
+// excludedFiles returns the files of a torrent to remove locally once it is
+// synchronised.
+func (f folderFilter) excludedFiles(torrent provider.Torrent) (files []provider.TorrentFile) {
+	if !f.files.deleteExcluded {
+		return
+	}
+	for _, file := range torrent.Files {
+		if !f.files.matches(file) {
+			files = append(files, file)
+		}
+	}
+	return
+}
+
+func anyRuleMatches(rules []torrentRule, torrent provider.Torrent) bool {
+	for _, rule := range rules {
+		if rule.matches(torrent) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r torrentRule) matches(torrent provider.Torrent) bool {
+	if r.name != nil && !r.name.MatchString(torrent.Name) {
+		return false
+	}
+	if len(r.labels) > 0 && !containsAny(r.labels, torrent.Labels, strings.EqualFold) {
+		return false
+	}
+	if len(r.trackers) > 0 && !containsAny(r.trackers, torrent.Trackers, isSameHost) {
+		return false
+	}
+	if r.minSize > 0 && torrent.Size < r.minSize {
+		return false
+	}
+	if r.maxSize > 0 && torrent.Size > r.maxSize {
+		return false
+	}
+	// Seeding goals are reached by time or by ratio, whichever comes first
+	if r.minSeedingTime > 0 || r.minRatio > 0 {
+		seeded := r.minSeedingTime > 0 && torrent.SeedingTime >= r.minSeedingTime
+		shared := r.minRatio > 0 && torrent.Ratio >= r.minRatio
+		if !seeded && !shared {
+			return false
+		}
+	}
+	if !r.addedBefore.IsZero() && !torrent.AddedDate.Before(r.addedBefore) {
+		return false
+	}
+	if !r.addedAfter.IsZero() && !torrent.AddedDate.After(r.addedAfter) {
+		return false
+	}
+	return true
+}
+
+func (f fileFilter) matches(file provider.TorrentFile) bool {
+	if f.minSize > 0 && file.Length < f.minSize {
+		return false
+	}
+	name := strings.ToLower(file.Name)
+	for _, extension := range f.extensions {
+		if strings.HasSuffix(name, extension) {
+			return false
+		}
+	}
+	if len(f.include) > 0 && !matchesGlob(f.include, name) {
+		return false
+	}
+	return !matchesGlob(f.exclude, name)
+}
+
+func matchesGlob(globs []string, name string) bool {
+	for _, glob := range globs {
+		if ok, _ := path.Match(glob, name); ok {
+			return true
+		}
+		if ok, _ := path.Match(glob, path.Base(name)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(wanted []string, values []string, equal func(string, string) bool) bool {
+	for _, w := range wanted {
+		for _, v := range values {
+			if equal(w, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isSameHost also accepts subdomains, trackers often announcing on several.
+func isSameHost(wanted string, host string) bool {
+	wanted = strings.ToLower(wanted)
+	host = strings.ToLower(host)
+	return host == wanted || strings.HasSuffix(host, "."+wanted)
+}
@@ -0,0 +1,165 @@
+package task
+
+import (
+	"seedbox-sync/model"
+	"seedbox-sync/provider"
+	"testing"
+	"time"
+)
+
+func TestTorrentRules(t *testing.T) {
+	torrent := provider.Torrent{
+		Name:        "Some.Show.S01E01.1080p",
+		Labels:      []string{"TV", "hd"},
+		Trackers:    []string{"tracker.example.org"},
+		Size:        5 << 30,
+		SeedingTime: 48 * time.Hour,
+		Ratio:       0.5,
+		AddedDate:   time.Date(2020, 6, 15, 12, 0, 0, 0, time.Local),
+	}
+	tests := []struct {
+		rule model.TorrentRule
+		want bool
+	}{
+		{model.TorrentRule{}, true},
+		{model.TorrentRule{Name: `(?i)s\d+e\d+`}, true},
+		{model.TorrentRule{Name: `^Movie`}, false},
+		{model.TorrentRule{Labels: []string{"tv"}}, true},
+		{model.TorrentRule{Labels: []string{"movies", "music"}}, false},
+		{model.TorrentRule{Trackers: []string{"Example.org"}}, true},
+		{model.TorrentRule{Trackers: []string{"other.example.org"}}, false},
+		{model.TorrentRule{MinSize: 1 << 30, MaxSize: 10 << 30}, true},
+		{model.TorrentRule{MaxSize: 1 << 30}, false},
+		{model.TorrentRule{MinSeedingTime: "24h"}, true},
+		{model.TorrentRule{MinSeedingTime: "72h"}, false},
+		// Either seeding goal is enough
+		{model.TorrentRule{MinSeedingTime: "72h", MinRatio: 0.5}, true},
+		{model.TorrentRule{MinSeedingTime: "72h", MinRatio: 1}, false},
+		{model.TorrentRule{AddedBefore: "2020-07-01"}, true},
+		{model.TorrentRule{AddedBefore: "2020-06-01"}, false},
+		{model.TorrentRule{AddedAfter: "2020-06-15T00:00:00Z", AddedBefore: "2020-06-16T00:00:00Z"}, true},
+		// All the criteria of a rule must match
+		{model.TorrentRule{Labels: []string{"tv"}, MaxSize: 1 << 30}, false},
+	}
+	for _, test := range tests {
+		rule, err := newTorrentRule(test.rule)
+		if err != nil {
+			t.Errorf("%+v: %v", test.rule, err)
+			continue
+		}
+		if got := rule.matches(torrent); got != test.want {
+			t.Errorf("%+v matches = %v, want %v", test.rule, got, test.want)
+		}
+	}
+}
+
+func TestInvalidTorrentRules(t *testing.T) {
+	for _, rule := range []model.TorrentRule{
+		{Name: "("},
+		{MinSeedingTime: "two days"},
+		{AddedBefore: "yesterday"},
+		{AddedAfter: "2020-13-01"},
+	} {
+		if _, err := newFolderFilter(model.Folder{Include: []model.TorrentRule{rule}}); err == nil {
+			t.Errorf("%+v is accepted", rule)
+		}
+	}
+}
+
+func TestFolderFilterMatches(t *testing.T) {
+	filter, err := newFolderFilter(model.Folder{
+		RemoteCompletePath: "/complete",
+		Include:            []model.TorrentRule{{Labels: []string{"tv"}}, {Labels: []string{"movies"}}},
+		Exclude:            []model.TorrentRule{{Name: "(?i)sample"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	completed := []provider.TorrentFile{{Name: "a", Length: 1, BytesCompleted: 1, Wanted: true}}
+	tests := []struct {
+		torrent provider.Torrent
+		want    bool
+	}{
+		{provider.Torrent{Name: "Show", DownloadDir: "/complete", Labels: []string{"tv"}, Files: completed}, true},
+		{provider.Torrent{Name: "Film", DownloadDir: "/complete", Labels: []string{"movies"}, Files: completed}, true},
+		{provider.Torrent{Name: "Album", DownloadDir: "/complete", Labels: []string{"music"}, Files: completed}, false},
+		{provider.Torrent{Name: "Show.Sample", DownloadDir: "/complete", Labels: []string{"tv"}, Files: completed}, false},
+		{provider.Torrent{Name: "Show", DownloadDir: "/elsewhere", Labels: []string{"tv"}, Files: completed}, false},
+		{provider.Torrent{Name: "Show", DownloadDir: "/complete", Labels: []string{"tv"}, Files: []provider.TorrentFile{
+			{Name: "a", Length: 2, BytesCompleted: 1, Wanted: true},
+		}}, false},
+	}
+	for _, test := range tests {
+		if got := filter.matches(test.torrent); got != test.want {
+			t.Errorf("%s in %s labelled %v matches = %v, want %v", test.torrent.Name, test.torrent.DownloadDir, test.torrent.Labels, got, test.want)
+		}
+	}
+}
+
+func TestFileRules(t *testing.T) {
+	filter, err := newFileFilter(model.FileRules{
+		Include:           []string{"*.MKV", "Subs/*"},
+		Exclude:           []string{"*sample*"},
+		ExcludeExtensions: []string{"NFO", ".txt"},
+		MinSize:           10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		length int64
+		want   bool
+	}{
+		{"Show/episode.mkv", 100, true},
+		{"Show/Subs/en.srt", 100, false},
+		{"Subs/en.srt", 100, true},
+		{"Show/episode.sample.mkv", 100, false},
+		{"Show/info.nfo", 100, false},
+		{"Show/readme.mkv.txt", 100, false},
+		{"Show/tiny.mkv", 5, false},
+	}
+	for _, test := range tests {
+		file := provider.TorrentFile{Name: test.name, Length: test.length}
+		if got := filter.matches(file); got != test.want {
+			t.Errorf("%s of %d bytes matches = %v, want %v", test.name, test.length, got, test.want)
+		}
+	}
+}
+
+func TestSelectedAndExcludedFiles(t *testing.T) {
+	filter, err := newFolderFilter(model.Folder{Files: model.FileRules{Exclude: []string{"*.nfo"}, Excluded: excludedDelete}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	torrent := provider.Torrent{Files: []provider.TorrentFile{
+		{Name: "a.mkv", Length: 1, BytesCompleted: 1, Wanted: true},
+		{Name: "b.nfo", Length: 1, BytesCompleted: 1, Wanted: true},
+		{Name: "c.mkv", Length: 1, BytesCompleted: 1, Wanted: false},
+		{Name: "d.mkv", Length: 2, BytesCompleted: 1, Wanted: true},
+	}}
+	names := func(files []provider.TorrentFile) (names []string) {
+		for _, file := range files {
+			names = append(names, file.Name)
+		}
+		return
+	}
+	if got := names(filter.selectedFiles(torrent)); len(got) != 1 || got[0] != "a.mkv" {
+		t.Errorf("selected files = %v, want [a.mkv]", got)
+	}
+	if got := names(filter.excludedFiles(torrent)); len(got) != 1 || got[0] != "b.nfo" {
+		t.Errorf("excluded files = %v, want [b.nfo]", got)
+	}
+}
+
+func TestInvalidFileRules(t *testing.T) {
+	for _, rules := range []model.FileRules{
+		{Include: []string{"["}},
+		{Exclude: []string{"a[b"}},
+		{Excluded: "archive"},
+	} {
+		if _, err := newFolderFilter(model.Folder{Files: rules}); err == nil {
+			t.Errorf("%+v is accepted", rules)
+		}
+	}
+}
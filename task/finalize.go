@@ -0,0 +1,211 @@
+package task
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+)
+
+// Files overwritten in the post processing path are set aside under this
+// suffix until the torrent is finalized, so that a rollback restores them.
+const replacedSuffix = ".replaced"
+
+// Ways of handing the files over from the temp path to the post processing
+// path. All but move keep the files in the temp path.
+const (
+	transferMove     = "move"
+	transferCopy     = "copy"
+	transferHardlink = "hardlink"
+	transferReflink  = "reflink"
+	transferSymlink  = "symlink"
+)
+
+func checkTransferMode(mode string) error {
+	switch mode {
+	case "", transferMove, transferCopy, transferHardlink, transferReflink, transferSymlink:
+		return nil
+	}
+	return fmt.Errorf("unknown transfer mode '%s'", mode)
+}
+
+func keepsTemp(mode string) bool {
+	return mode != "" && mode != transferMove
+}
+
+// transaction records the local transfers made while finalizing a torrent so
+// that they can be undone when a later step fails.
+type transaction struct {
+	mode      string
+	perms     permissions
+	moves     []move
+	replaced  []move
+	created   []string
+	discarded []string
+}
+
+type move struct {
+	from string
+	to   string
+}
+
+func (t *transaction) move(oldName, newName string) error {
+	if err := transferLocal(t.mode, t.perms, oldName, newName); err != nil {
+		return err
+	}
+	t.moves = append(t.moves, move{from: oldName, to: newName})
+	if t.mode == transferSymlink {
+		return t.perms.applyLink(newName)
+	}
+	return t.perms.applyFile(newName)
+}
+
+// replace sets aside a file about to be overwritten.
+func (t *transaction) replace(name string) error {
+	aside := name + replacedSuffix
+	if err := os.Rename(name, aside); err != nil {
+		return err
+	}
+	t.replaced = append(t.replaced, move{from: name, to: aside})
+	return nil
+}
+
+// create records a file written in the post processing path.
+func (t *transaction) create(name string) {
+	t.created = append(t.created, name)
+}
+
+// discard deletes a file once the torrent is finalized.
+func (t *transaction) discard(name string) {
+	t.discarded = append(t.discarded, name)
+}
+
+func (t *transaction) rollback() (err error) {
+	for i := len(t.created) - 1; i >= 0; i-- {
+		if e := os.Remove(t.created[i]); e != nil && err == nil {
+			err = e
+		}
+	}
+	t.created = nil
+	t.discarded = nil
+	for i := len(t.moves) - 1; i >= 0; i-- {
+		m := t.moves[i]
+		var e error
+		if keepsTemp(t.mode) {
+			e = os.Remove(m.to)
+		} else {
+			e = moveLocal(t.perms, m.to, m.from)
+		}
+		if e != nil && err == nil {
+			err = e
+		}
+	}
+	t.moves = nil
+	for i := len(t.replaced) - 1; i >= 0; i-- {
+		m := t.replaced[i]
+		if e := os.Rename(m.to, m.from); e != nil && err == nil {
+			err = e
+		}
+	}
+	t.replaced = nil
+	return
+}
+
+// commit deletes the files replaced, and the discarded ones, for good.
+func (t *transaction) commit() (err error) {
+	for _, m := range t.replaced {
+		if e := os.Remove(m.to); e != nil && err == nil {
+			err = e
+		}
+	}
+	t.replaced = nil
+	for _, name := range t.discarded {
+		if e := os.Remove(name); e != nil && err == nil {
+			err = e
+		}
+	}
+	t.discarded = nil
+	return
+}
+
+func transferLocal(mode string, perms permissions, oldName, newName string) (err error) {
+	if err = perms.mkdirAll(filepath.Dir(newName)); err != nil {
+		return
+	}
+	switch mode {
+	case "", transferMove:
+		err = moveLocal(perms, oldName, newName)
+	case transferCopy:
+		err = copyFile(oldName, newName)
+	case transferHardlink:
+		err = os.Link(oldName, newName)
+	case transferReflink:
+		// Filesystems without shared extents get a plain copy
+		if err = reflink(oldName, newName); err == nil {
+			err = preserveTime(oldName, newName)
+		} else {
+			err = copyFile(oldName, newName)
+		}
+	case transferSymlink:
+		var target string
+		if target, err = filepath.Abs(oldName); err == nil {
+			err = os.Symlink(target, newName)
+		}
+	default:
+		err = checkTransferMode(mode)
+	}
+	return
+}
+
+func moveLocal(perms permissions, oldName, newName string) (err error) {
+	if err = perms.mkdirAll(filepath.Dir(newName)); err != nil {
+		return
+	}
+	err = os.Rename(oldName, newName)
+	le, ok := err.(*os.LinkError)
+	if !ok {
+		return err
+	}
+	// 0x11 is Win32 Error Code ERROR_NOT_SAME_DEVICE (https://msdn.microsoft.com/en-us/library/cc231199.aspx)
+	if le.Err == syscall.Errno(0x12) || (runtime.GOOS == "windows" && le.Err == syscall.Errno(0x11)) {
+		err = moveFile(oldName, newName)
+	}
+	return
+}
+
+func moveFile(sourcePath, destPath string) error {
+	if err := copyFile(sourcePath, destPath); err != nil {
+		return err
+	}
+	// The copy was successful, so now delete the original file
+	err := os.Remove(sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed removing original file: %s", err)
+	}
+	return nil
+}
+
+func copyFile(sourcePath, destPath string) error {
+	inputFile, err := os.Open(sourcePath)
+	if err != nil {
+		return fmt.Errorf("couldn't open source file: %s", err)
+	}
+	outputFile, err := os.Create(destPath)
+	if err != nil {
+		inputFile.Close()
+		return fmt.Errorf("couldn't open dest file: %s", err)
+	}
+	_, err = io.Copy(outputFile, inputFile)
+	inputFile.Close()
+	if closeErr := outputFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// Do not leave a truncated copy behind
+		os.Remove(destPath)
+		return fmt.Errorf("writing to output file failed: %s", err)
+	}
+	return preserveTime(sourcePath, destPath)
+}
@@ -0,0 +1,50 @@
+package task
+
+import (
+	"fmt"
+	"seedbox-sync/state"
+	"sort"
+	"time"
+)
+
+type Inspect struct {
+	store *state.Store
+	hash  string
+}
+
+func NewInspect(store *state.Store, hash string) *Inspect {
+	return &Inspect{
+		store: store,
+		hash:  hash,
+	}
+}
+
+func (i *Inspect) Execute() {
+	if i.hash == "" {
+		for _, torrent := range i.store.Torrents() {
+			fmt.Printf("%s  %-11s  %s  %s (%d files)\n", torrent.Hash, torrent.Status, torrent.UpdatedAt.Format(time.RFC3339), torrent.Name, len(torrent.Files))
+		}
+		return
+	}
+
+	torrent, ok := i.store.Torrent(i.hash)
+	if !ok {
+		fmt.Println("unknown torrent", i.hash)
+		return
+	}
+	fmt.Println("Hash:   ", torrent.Hash)
+	fmt.Println("Name:   ", torrent.Name)
+	fmt.Println("Folder: ", torrent.Folder)
+	fmt.Println("Status: ", torrent.Status)
+	fmt.Println("Updated:", torrent.UpdatedAt.Format(time.RFC3339))
+
+	names := make([]string, 0, len(torrent.Files))
+	for name := range torrent.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		file := torrent.Files[name]
+		fmt.Printf("  %-11s  %12d  %s  %s\n", file.Status, file.Bytes, file.Checksum, name)
+	}
+}
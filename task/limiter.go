@@ -0,0 +1,32 @@
+package task
+
+import "sync"
+
+// limiter runs functions in the background, never more than size at once.
+type limiter struct {
+	slots chan struct{}
+	wg    sync.WaitGroup
+}
+
+func newLimiter(size int) *limiter {
+	if size < 1 {
+		size = 1
+	}
+	return &limiter{slots: make(chan struct{}, size)}
+}
+
+func (l *limiter) run(f func()) {
+	l.slots <- struct{}{}
+	l.wg.Add(1)
+	go func() {
+		defer func() {
+			<-l.slots
+			l.wg.Done()
+		}()
+		f()
+	}()
+}
+
+func (l *limiter) wait() {
+	l.wg.Wait()
+}
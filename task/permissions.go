@@ -0,0 +1,130 @@
+package task
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"seedbox-sync/model"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultFileMode os.FileMode = 0644
+	defaultDirMode  os.FileMode = 0755
+)
+
+// permissions applies the modes and ownership configured for a folder to the
+// files and directories it creates.
+type permissions struct {
+	fileMode os.FileMode
+	dirMode  os.FileMode
+	chmod    bool
+	uid      int
+	gid      int
+}
+
+func newPermissions(p model.Permissions) (perms permissions, err error) {
+	perms = permissions{fileMode: defaultFileMode, dirMode: defaultDirMode, uid: -1, gid: -1}
+	var umask os.FileMode
+	for _, mode := range []struct {
+		name  string
+		value string
+		mode  *os.FileMode
+	}{
+		{"file mode", p.FileMode, &perms.fileMode},
+		{"directory mode", p.DirMode, &perms.dirMode},
+		{"umask", p.Umask, &umask},
+	} {
+		if mode.value == "" {
+			continue
+		}
+		parsed, e := strconv.ParseUint(mode.value, 8, 32)
+		if e != nil || parsed > 0777 {
+			err = fmt.Errorf("invalid %s '%s'", mode.name, mode.value)
+			return
+		}
+		*mode.mode = os.FileMode(parsed)
+		perms.chmod = true
+	}
+	perms.fileMode &^= umask
+	perms.dirMode &^= umask
+	if p.Uid != nil {
+		perms.uid = *p.Uid
+	}
+	if p.Gid != nil {
+		perms.gid = *p.Gid
+	}
+	return
+}
+
+// permissions returns the permissions of a folder, checked with its rules.
+func (s *Sync) permissions(folder model.Folder) permissions {
+	perms, _ := newPermissions(folder.Permissions)
+	return perms
+}
+
+func (p permissions) applyFile(name string) error {
+	return p.apply(name, p.fileMode)
+}
+
+func (p permissions) applyDir(name string) error {
+	return p.apply(name, p.dirMode)
+}
+
+func (p permissions) apply(name string, mode os.FileMode) error {
+	if p.chmod {
+		if err := os.Chmod(name, mode); err != nil {
+			return err
+		}
+	}
+	return p.chown(name)
+}
+
+// applyLink only changes the ownership of a symbolic link, links having no
+// mode of their own on most systems.
+func (p permissions) applyLink(name string) error {
+	if p.uid < 0 && p.gid < 0 {
+		return nil
+	}
+	return os.Lchown(name, p.uid, p.gid)
+}
+
+func (p permissions) chown(name string) error {
+	if p.uid < 0 && p.gid < 0 {
+		return nil
+	}
+	return os.Chown(name, p.uid, p.gid)
+}
+
+// mkdirAll creates a directory and its missing parents, applying the
+// permissions to those it creates.
+func (p permissions) mkdirAll(path string) error {
+	if fi, err := os.Stat(path); err == nil {
+		if fi.IsDir() {
+			return nil
+		}
+		return fmt.Errorf("%s is not a directory", path)
+	}
+	if parent := filepath.Dir(path); parent != path {
+		if err := p.mkdirAll(parent); err != nil {
+			return err
+		}
+	}
+	if err := os.Mkdir(path, p.dirMode); err != nil {
+		if os.IsExist(err) {
+			return nil
+		}
+		return err
+	}
+	return p.applyDir(path)
+}
+
+// preserveTime gives a file the modification time of another.
+func preserveTime(sourcePath, destPath string) error {
+	fi, err := os.Stat(sourcePath)
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(destPath, time.Now(), fi.ModTime())
+}
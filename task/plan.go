@@ -0,0 +1,335 @@
+package task
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"seedbox-sync/downloader"
+	"seedbox-sync/model"
+	"seedbox-sync/notifier"
+	"seedbox-sync/provider"
+	"seedbox-sync/state"
+	"strings"
+)
+
+const (
+	PlanText = "text"
+	PlanJSON = "json"
+)
+
+const (
+	ActionDownload = "download"
+	ActionResume   = "resume"
+	ActionRestart  = "restart"
+	ActionSkip     = "skip"
+	ActionError    = "error"
+)
+
+// Plan computes what a synchronisation would do, without writing anything
+// locally nor changing anything on the provider.
+type Plan struct {
+	sync   *Sync
+	format string
+	output io.Writer
+}
+
+type FolderPlan struct {
+	RemoteCompletePath string        `json:"remoteCompletePath"`
+	Error              string        `json:"error,omitempty"`
+	Torrents           []TorrentPlan `json:"torrents"`
+}
+
+type TorrentPlan struct {
+	Hash        string           `json:"hash"`
+	Name        string           `json:"name"`
+	Partial     bool             `json:"partial"`
+	Skip        string           `json:"skip,omitempty"`
+	Files       []FilePlan       `json:"files"`
+	Moves       []MovePlan       `json:"moves"`
+	SetLocation *SetLocationPlan `json:"setLocation,omitempty"`
+	Deletes     []string         `json:"deletes"`
+}
+
+type FilePlan struct {
+	Name     string `json:"name"`
+	Action   string `json:"action"`
+	Offset   int64  `json:"offset"`
+	Size     int64  `json:"size"`
+	Segments int    `json:"segments,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+type MovePlan struct {
+	Mode     string `json:"mode"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Conflict string `json:"conflict,omitempty"`
+}
+
+type SetLocationPlan struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+func NewPlan(folders []model.Folder, transfer model.TransferConfiguration, provider provider.Provider, pool *downloader.Pool, store *state.Store, format string) *Plan {
+	return &Plan{
+		sync:   NewSync(folders, transfer, provider, pool, store, notifier.NewCompose(), os.Stdout),
+		format: format,
+		output: os.Stdout,
+	}
+}
+
+func (p *Plan) Execute() {
+	defer p.sync.pool.Close()
+
+	torrents, err := p.sync.provider.GetTorrents()
+	if err != nil {
+		fmt.Println("unable to retrieve the torrents:", err)
+		return
+	}
+
+	var folders []FolderPlan
+	for _, folder := range p.sync.folders {
+		folderPlan := FolderPlan{
+			RemoteCompletePath: folder.RemoteCompletePath,
+			Torrents:           []TorrentPlan{},
+		}
+		filter, err := newFolderFilter(folder)
+		if err != nil {
+			folderPlan.Error = fmt.Sprint("invalid rules: ", err)
+			folders = append(folders, folderPlan)
+			continue
+		}
+		for _, torrent := range torrents {
+			if filter.matches(torrent) {
+				folderPlan.Torrents = append(folderPlan.Torrents, p.planTorrent(folder, filter, torrent))
+			}
+		}
+		folders = append(folders, folderPlan)
+	}
+
+	if p.format == PlanJSON {
+		err = p.writeJSON(folders)
+	} else {
+		err = p.writeText(folders)
+	}
+	if err != nil {
+		fmt.Println("unable to print the plan:", err)
+	}
+}
+
+func (p *Plan) planTorrent(folder model.Folder, filter folderFilter, torrent provider.Torrent) TorrentPlan {
+	plan := TorrentPlan{
+		Hash:    torrent.Hash,
+		Name:    torrent.Name,
+		Partial: !torrent.IsCompleted(),
+		Files:   []FilePlan{},
+		Moves:   []MovePlan{},
+		Deletes: []string{},
+	}
+	record, _ := p.sync.store.Torrent(torrentKey(torrent))
+
+	if !plan.Partial && record.Status != state.StatusMoved {
+		_, reason, err := p.sync.spaceProblem(folder, filter.selectedFiles(torrent))
+		if err != nil {
+			reason = fmt.Sprint("unable to check the free space: ", err)
+		}
+		if reason != "" {
+			plan.Skip = reason
+			return plan
+		}
+	}
+
+	if !plan.Partial {
+		plan.SetLocation = &SetLocationPlan{
+			From: torrent.DownloadDir,
+			To:   folder.RemoteSharePath,
+		}
+		for _, file := range filter.excludedFiles(torrent) {
+			for _, localFile := range []string{folder.LocalTempPath + "/" + file.Name, p.sync.partFile(folder, file.Name)} {
+				if _, err := os.Stat(localFile); err == nil {
+					plan.Deletes = append(plan.Deletes, localFile)
+				}
+			}
+		}
+
+		// Files moved by a previous run only miss their remote move
+		if record.Status == state.StatusMoved {
+			return plan
+		}
+	}
+
+	// Files of a partial torrent are only downloaded
+	for _, file := range filter.selectedFiles(torrent) {
+		plan.Files = append(plan.Files, p.planFile(folder, record, file))
+		if !plan.Partial {
+			move := MovePlan{
+				Mode: transferMove,
+				From: folder.LocalTempPath + "/" + file.Name,
+				To:   folder.LocalPostProcessingPath + "/" + file.Name,
+			}
+			if folder.TransferMode != "" {
+				move.Mode = folder.TransferMode
+			}
+			if decision, dest, err := resolveConflict(folder.Conflict, file.Length, move.To); err == nil {
+				move.To, move.Conflict = dest, decision
+			}
+			plan.Moves = append(plan.Moves, move)
+		}
+	}
+	return plan
+}
+
+// planFile mirrors the decisions of Sync.downloadFile.
+func (p *Plan) planFile(folder model.Folder, record state.Torrent, file provider.TorrentFile) FilePlan {
+	plan := FilePlan{
+		Name: file.Name,
+		Size: file.Length,
+	}
+
+	if f, ok := record.Files[file.Name]; ok && f.Status == state.StatusDownloaded {
+		if fi, err := os.Stat(folder.LocalTempPath + "/" + file.Name); err == nil && f.Bytes == fi.Size() {
+			plan.Action = ActionSkip
+			plan.Offset = fi.Size()
+			plan.Reason = "already downloaded"
+			return plan
+		}
+	}
+	localFile, localSize := p.sync.stagedFile(folder, file.Name)
+
+	d, err := p.sync.pool.Get()
+	if err != nil {
+		plan.Action = ActionError
+		plan.Reason = err.Error()
+		return plan
+	}
+	remoteFile := strings.Replace(folder.RemoteCompletePath, d.GetRoot(), "", 1) + "/" + file.Name
+	remoteSize, err := d.GetRemoteSize(file, folder.RemoteCompletePath)
+	if err != nil {
+		p.sync.pool.Discard(d)
+		plan.Action = ActionError
+		plan.Reason = err.Error()
+		return plan
+	}
+	modTime := remoteModTime(d, file, folder.RemoteCompletePath)
+	p.sync.pool.Put(d)
+	plan.Size = remoteSize
+
+	if localSize > 0 {
+		reason, err := p.sync.resumeProblem(record, file, remoteFile, localFile, localSize, remoteSize, modTime)
+		if err != nil {
+			plan.Action = ActionError
+			plan.Reason = err.Error()
+			return plan
+		}
+		if reason != "" {
+			plan.Action = ActionRestart
+			plan.Reason = reason
+			return plan
+		}
+	}
+
+	if p.sync.isSegmented(localFile, localSize, remoteSize) {
+		segments := p.sync.resumeSegments(localFile, localSize, remoteSize)
+		plan.Offset = segments.done()
+		plan.Segments = segments.pending()
+	} else if localSize < remoteSize {
+		plan.Offset = localSize
+	} else {
+		plan.Action = ActionSkip
+		plan.Offset = localSize
+		plan.Reason = "already complete"
+		return plan
+	}
+
+	if plan.Offset > 0 {
+		plan.Action = ActionResume
+	} else {
+		plan.Action = ActionDownload
+	}
+	return plan
+}
+
+func (p *Plan) writeJSON(folders []FolderPlan) error {
+	encoder := json.NewEncoder(p.output)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(struct {
+		Folders []FolderPlan `json:"folders"`
+	}{folders})
+}
+
+func (p *Plan) writeText(folders []FolderPlan) (err error) {
+	w := &planWriter{output: p.output}
+	for _, folder := range folders {
+		if folder.Error != "" {
+			w.printf("Folder %s skipped: %s\n", folder.RemoteCompletePath, folder.Error)
+			continue
+		}
+		w.printf("Folder %s (%d torrents)\n", folder.RemoteCompletePath, len(folder.Torrents))
+		for _, torrent := range folder.Torrents {
+			if torrent.Skip != "" {
+				w.printf("  Torrent %s [%s] skipped: %s\n", torrent.Name, torrent.Hash, torrent.Skip)
+				continue
+			}
+			if torrent.Partial {
+				w.printf("  Torrent %s [%s] (partial)\n", torrent.Name, torrent.Hash)
+			} else {
+				w.printf("  Torrent %s [%s]\n", torrent.Name, torrent.Hash)
+			}
+			for _, file := range torrent.Files {
+				switch file.Action {
+				case ActionResume:
+					w.printf("    resume    %s from %s of %s", file.Name, formatSize(file.Offset), formatSize(file.Size))
+				case ActionRestart, ActionSkip, ActionError:
+					w.printf("    %-9s %s (%s)", file.Action, file.Name, file.Reason)
+				default:
+					w.printf("    %-9s %s (%s)", file.Action, file.Name, formatSize(file.Size))
+				}
+				if file.Segments > 0 {
+					w.printf(" in %d segments", file.Segments)
+				}
+				w.printf("\n")
+			}
+			for _, move := range torrent.Moves {
+				if move.Conflict != "" {
+					w.printf("    %-9s %s -> %s (exists: %s)\n", move.Mode, move.From, move.To, move.Conflict)
+				} else {
+					w.printf("    %-9s %s -> %s\n", move.Mode, move.From, move.To)
+				}
+			}
+			if torrent.SetLocation != nil {
+				w.printf("    location  %s -> %s\n", torrent.SetLocation.From, torrent.SetLocation.To)
+			}
+			for _, name := range torrent.Deletes {
+				w.printf("    delete    %s\n", name)
+			}
+		}
+	}
+	return w.err
+}
+
+// planWriter keeps the first write error so that printing goes on unchecked.
+type planWriter struct {
+	output io.Writer
+	err    error
+}
+
+func (w *planWriter) printf(format string, a ...interface{}) {
+	if w.err == nil {
+		_, w.err = fmt.Fprintf(w.output, format, a...)
+	}
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
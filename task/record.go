@@ -0,0 +1,45 @@
+package task
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"seedbox-sync/model"
+	"seedbox-sync/provider"
+	"seedbox-sync/state"
+)
+
+func torrentKey(torrent provider.Torrent) string {
+	if torrent.Hash != "" {
+		return torrent.Hash
+	}
+	return torrent.Name
+}
+
+func (s *Sync) recordTorrent(folder model.Folder, torrent provider.Torrent, status string) {
+	if err := s.store.SetTorrentStatus(torrentKey(torrent), torrent.Name, folder.RemoteCompletePath, status); err != nil {
+		fmt.Fprintln(s.output, "unable to save the state of", torrent.Name, ":", err)
+	}
+}
+
+func (s *Sync) recordFile(torrent provider.Torrent, name string, file state.File) {
+	if err := s.store.SetFile(torrentKey(torrent), name, file); err != nil {
+		fmt.Fprintln(s.output, "unable to save the state of", name, ":", err)
+	}
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
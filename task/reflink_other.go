@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package task
+
+import "errors"
+
+func reflink(oldName, newName string) error {
+	return errors.New("reflinks are not supported on this platform")
+}
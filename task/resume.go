@@ -0,0 +1,117 @@
+package task
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"seedbox-sync/downloader"
+	"seedbox-sync/provider"
+	"seedbox-sync/state"
+	"time"
+)
+
+// The end of the local part of a file is compared with the remote one before
+// resuming, to make sure both are the same file.
+const resumeWindow = 64 << 10
+
+// remoteModTime returns the modification time of a remote file, or the zero
+// time when the downloader cannot tell.
+func remoteModTime(d downloader.Downloader, file provider.TorrentFile, remoteCompletePath string) time.Time {
+	if modTimer, ok := d.(downloader.ModTimer); ok {
+		if modTime, err := modTimer.GetRemoteModTime(file, remoteCompletePath); err == nil {
+			return modTime
+		}
+	}
+	return time.Time{}
+}
+
+// resumeProblem tells why the local part of a file cannot be resumed, if so.
+func (s *Sync) resumeProblem(record state.Torrent, file provider.TorrentFile, remoteFile string, localFile string, localSize int64, remoteSize int64, modTime time.Time) (reason string, err error) {
+	if localSize > remoteSize {
+		return "local file larger than the remote one", nil
+	}
+
+	resume, resumeErr := loadResumeState(localFile)
+	if resumeErr == nil && resume.Size != remoteSize {
+		return "remote file modified", nil
+	}
+	if resumeErr == nil && len(resume.Segments) > 0 && localSize != resume.Size {
+		return "local file does not match its segments", nil
+	}
+
+	// The modification time recorded when the download started is enough,
+	// when known, to tell whether the remote file was replaced
+	recorded := resume.ModTime
+	if f, ok := record.Files[file.Name]; ok && recorded.IsZero() {
+		recorded = f.ModTime
+	}
+	if !recorded.IsZero() && !modTime.IsZero() {
+		if !recorded.Equal(modTime) {
+			return "remote file modified", nil
+		}
+		return "", nil
+	}
+
+	// Segmented files are allocated at once, their tail means nothing
+	if resumeErr == nil && len(resume.Segments) > 0 {
+		return "", nil
+	}
+
+	same, err := s.sameTail(remoteFile, localFile, localSize)
+	if err != nil || same {
+		return
+	}
+	return "local content differs from the remote one", nil
+}
+
+// sameTail compares the last bytes of the local part of a file with the
+// remote bytes at the same offset.
+func (s *Sync) sameTail(remoteFile string, localFile string, localSize int64) (bool, error) {
+	window := int64(resumeWindow)
+	if window > localSize {
+		window = localSize
+	}
+	offset := localSize - window
+
+	local := make([]byte, window)
+	open, err := os.Open(localFile)
+	if err != nil {
+		return false, err
+	}
+	_, err = open.ReadAt(local, offset)
+	open.Close()
+	if err != nil {
+		return false, err
+	}
+
+	remote := make([]byte, window)
+	err = s.readRemote(relativeTo(remoteFile), offset, func(reader io.Reader) error {
+		_, err := io.ReadFull(reader, remote)
+		return err
+	})
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(local, remote), nil
+}
+
+// restart drops the local part of a file so that it is downloaded again.
+func restart(partFile string) error {
+	if err := os.Remove(partFile + resumeSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Truncate(partFile, 0)
+}
+
+func (s *Sync) checkResume(record state.Torrent, file provider.TorrentFile, remoteFile string, localFile string, localSize int64, remoteSize int64, modTime time.Time) (int64, error) {
+	reason, err := s.resumeProblem(record, file, remoteFile, localFile, localSize, remoteSize, modTime)
+	if err != nil || reason == "" {
+		return localSize, err
+	}
+	fmt.Fprintln(s.output, "restarting", file.Name, ":", reason)
+	return 0, restart(localFile)
+}
@@ -1,10 +1,12 @@
 package task
 
 import (
+	"io"
 	"seedbox-sync/downloader"
 	"seedbox-sync/model"
 	"seedbox-sync/notifier"
 	"seedbox-sync/provider"
+	"seedbox-sync/state"
 
 	"github.com/robfig/cron/v3"
 )
@@ -20,16 +22,10 @@ func (s *Scheduler) Execute() {
 	s.c.Run()
 }
 
-func NewSchedule(configuration model.SchedulerConfiguration, folders []model.Folder, provider provider.Provider, downloader downloader.Downloader, notifier notifier.Notifier) *Scheduler {
-	sync := &Sync{
-		folders:    folders,
-		provider:   provider,
-		downloader: downloader,
-		notifier:   notifier,
-	}
+func NewSchedule(configuration model.SchedulerConfiguration, folders []model.Folder, transfer model.TransferConfiguration, provider provider.Provider, pool *downloader.Pool, store *state.Store, notifier notifier.Notifier, output io.Writer) *Scheduler {
 	return &Scheduler{
 		configuration: configuration,
-		sync:          sync,
+		sync:          NewSync(folders, transfer, provider, pool, store, notifier, output),
 		c:             cron.New(),
 	}
 
@@ -0,0 +1,228 @@
+package task
+
+import (
+	"io"
+	"os"
+	"seedbox-sync/provider"
+	"sync"
+	"time"
+)
+
+// Segment progress is persisted every few megabytes so that an interrupted
+// run does not fetch again more than this per segment.
+const segmentsSaveInterval = 4 << 20
+
+type segment struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+	Done  int64 `json:"done"`
+}
+
+func (s segment) remaining() int64 {
+	return s.End - s.Start - s.Done
+}
+
+func (s *Sync) isSegmented(partFile string, localSize int64, remoteSize int64) bool {
+	if _, ok := loadSegments(partFile, localSize); ok {
+		return true
+	}
+	if localSize >= remoteSize {
+		return false
+	}
+	segments := s.transfer.Segments
+	if segments > s.pool.Size() {
+		segments = s.pool.Size()
+	}
+	return segments > 1 && s.transfer.SegmentThreshold > 0 && remoteSize >= s.transfer.SegmentThreshold
+}
+
+func (s *Sync) downloadSegments(file provider.TorrentFile, remoteFile string, partFile string, localSize int64, remoteSize int64, modTime time.Time) error {
+	state := s.resumeSegments(partFile, localSize, remoteSize)
+	state.ModTime = modTime
+
+	open, err := os.OpenFile(partFile, os.O_RDWR|os.O_CREATE, defaultFileMode)
+	if err != nil {
+		return err
+	}
+	defer open.Close()
+	if err = open.Truncate(remoteSize); err != nil {
+		return err
+	}
+
+	progress := newFileProgress(file, state.done(), s.notifier)
+
+	var access sync.Mutex
+	save := func() error {
+		access.Lock()
+		defer access.Unlock()
+		return state.save(partFile)
+	}
+	if err = save(); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(state.Segments))
+	for i := range state.Segments {
+		if state.Segments[i].remaining() <= 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = s.downloadSegment(remoteFile, open, &state.Segments[i], &access, progress, save)
+		}(i)
+	}
+	wg.Wait()
+
+	saveErr := save()
+	for _, e := range errs {
+		if e != nil {
+			return e
+		}
+	}
+	return saveErr
+}
+
+func (s *Sync) downloadSegment(remoteFile string, open *os.File, seg *segment, access *sync.Mutex, progress *fileProgress, save func() error) error {
+	access.Lock()
+	offset := seg.Start + seg.Done
+	remaining := seg.remaining()
+	access.Unlock()
+
+	return s.readRemote(relativeTo(remoteFile), offset, func(reader io.Reader) (err error) {
+		proxyReader := &ProxyReader{
+			reader:   io.LimitReader(reader, remaining),
+			progress: progress,
+		}
+
+		buf := make([]byte, 32*1024)
+		var unsaved int64
+		for remaining > 0 {
+			n, readErr := proxyReader.Read(buf)
+			if n > 0 {
+				if _, err = open.WriteAt(buf[:n], offset); err != nil {
+					return
+				}
+				offset += int64(n)
+				remaining -= int64(n)
+				unsaved += int64(n)
+				access.Lock()
+				seg.Done += int64(n)
+				access.Unlock()
+				if unsaved >= segmentsSaveInterval {
+					unsaved = 0
+					if err = save(); err != nil {
+						return
+					}
+				}
+			}
+			if readErr == io.EOF && remaining > 0 {
+				return io.ErrUnexpectedEOF
+			}
+			if readErr != nil && readErr != io.EOF {
+				return readErr
+			}
+		}
+		return
+	})
+}
+
+// resumeSegments loads the segments of an interrupted download, or splits the
+// file anew.
+func (s *Sync) resumeSegments(partFile string, localSize int64, remoteSize int64) resumeState {
+	state, ok := loadSegments(partFile, localSize)
+	if ok && state.Size == remoteSize {
+		return state
+	}
+	// Either a new or streamed download, or stale segments: keep what was
+	// already downloaded in a single stream and split the rest, unless it
+	// is a preallocated file
+	if len(state.Segments) > 0 {
+		localSize = 0
+	}
+	return newSegmentsState(localSize, remoteSize, s.transfer.Segments)
+}
+
+// loadSegments returns the segments of an interrupted download, which only
+// hold as long as the part file is still there at its preallocated size.
+func loadSegments(partFile string, localSize int64) (state resumeState, ok bool) {
+	state, err := loadResumeState(partFile)
+	if err != nil {
+		return resumeState{}, false
+	}
+	return state, len(state.Segments) > 0 && localSize == state.Size
+}
+
+func newSegmentsState(start int64, size int64, count int) resumeState {
+	state := resumeState{Size: size}
+	if count < 1 {
+		count = 1
+	}
+	length := (size - start + int64(count) - 1) / int64(count)
+	for offset := start; offset < size; offset += length {
+		end := offset + length
+		if end > size {
+			end = size
+		}
+		state.Segments = append(state.Segments, segment{Start: offset, End: end})
+	}
+	return state
+}
+
+// done returns the number of bytes already downloaded.
+func (s resumeState) done() (done int64) {
+	for _, seg := range s.Segments {
+		done += seg.Done
+	}
+	if len(s.Segments) > 0 {
+		done += s.Segments[0].Start
+	}
+	return
+}
+
+func (s resumeState) pending() (count int) {
+	for _, seg := range s.Segments {
+		if seg.remaining() > 0 {
+			count++
+		}
+	}
+	return
+}
+
+// readRemote hands a remote file, read from offset with a pooled connection,
+// to read. remoteFile gives its path from the root of the downloader.
+func (s *Sync) readRemote(remoteFile func(root string) string, offset int64, read func(io.Reader) error) error {
+	d, err := s.pool.Get()
+	if err != nil {
+		return err
+	}
+	reader, err := d.GetFile(remoteFile(d.GetRoot()), uint64(offset))
+	if err != nil {
+		s.pool.Discard(d)
+		return err
+	}
+	err = read(reader)
+	// Stopping a transfer before its end may leave the connection unusable
+	if closeErr := closeReader(reader); err != nil || closeErr != nil {
+		s.pool.Discard(d)
+	} else {
+		s.pool.Put(d)
+	}
+	return err
+}
+
+// relativeTo is used for remote files already given from the root of the
+// downloader.
+func relativeTo(remoteFile string) func(string) string {
+	return func(string) string {
+		return remoteFile
+	}
+}
+
+func closeReader(reader io.Reader) error {
+	if closer, ok := reader.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package task
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNewSegmentsState(t *testing.T) {
+	tests := []struct {
+		start, size int64
+		count       int
+		want        []segment
+	}{
+		{0, 10, 3, []segment{{Start: 0, End: 4}, {Start: 4, End: 8}, {Start: 8, End: 10}}},
+		{4, 10, 2, []segment{{Start: 4, End: 7}, {Start: 7, End: 10}}},
+		{0, 10, 0, []segment{{Start: 0, End: 10}}},
+		{0, 2, 4, []segment{{Start: 0, End: 1}, {Start: 1, End: 2}}},
+		{10, 10, 4, nil},
+	}
+	for _, test := range tests {
+		state := newSegmentsState(test.start, test.size, test.count)
+		if state.Size != test.size || !reflect.DeepEqual(state.Segments, test.want) {
+			t.Errorf("newSegmentsState(%d, %d, %d) = %+v, want %+v", test.start, test.size, test.count, state.Segments, test.want)
+		}
+		if done := state.done(); done != test.start && len(test.want) > 0 {
+			t.Errorf("newSegmentsState(%d, %d, %d) has %d bytes done, want %d", test.start, test.size, test.count, done, test.start)
+		}
+	}
+}
+
+func TestResumeStateProgress(t *testing.T) {
+	state := resumeState{Size: 100, Segments: []segment{
+		{Start: 10, End: 40, Done: 30},
+		{Start: 40, End: 70, Done: 5},
+		{Start: 70, End: 100},
+	}}
+	if done := state.done(); done != 45 {
+		t.Errorf("done = %d, want 45", done)
+	}
+	if pending := state.pending(); pending != 2 {
+		t.Errorf("pending = %d, want 2", pending)
+	}
+}
+
+func TestLoadSegments(t *testing.T) {
+	partFile := filepath.Join(t.TempDir(), "a.mkv.part")
+	if _, ok := loadSegments(partFile, 0); ok {
+		t.Error("segments loaded without resume file")
+	}
+
+	state := newSegmentsState(0, 100, 2)
+	if err := state.save(partFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := loadSegments(partFile, 100); !ok {
+		t.Error("segments of a preallocated part file not loaded")
+	}
+	// The part file is missing or was truncated since
+	for _, size := range []int64{0, 50} {
+		if _, ok := loadSegments(partFile, size); ok {
+			t.Errorf("segments loaded for a part file of %d bytes", size)
+		}
+	}
+
+	if err := (resumeState{Size: 100}).save(partFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := loadSegments(partFile, 100); ok {
+		t.Error("segments loaded for a streamed download")
+	}
+}
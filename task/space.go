@@ -0,0 +1,186 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"seedbox-sync/model"
+	"seedbox-sync/provider"
+	"sync"
+)
+
+var errSpaceUnsupported = errors.New("free space is unknown on this platform")
+
+// spaceReservations keeps the bytes promised to the torrents being downloaded,
+// per filesystem, so that concurrent torrents do not count the same free
+// space. Bytes already written are counted twice until the torrent ends,
+// which only errs on the safe side.
+type spaceReservations struct {
+	reserved map[string]int64
+	access   sync.Mutex
+}
+
+type spaceDemand struct {
+	device string
+	path   string
+	bytes  int64
+}
+
+// reserveSpace makes sure a torrent fits in the local paths of its folder
+// before downloading it, returning why it does not otherwise.
+func (s *Sync) reserveSpace(folder model.Folder, files []provider.TorrentFile) (release func(), reason string, err error) {
+	release = func() {}
+	s.space.access.Lock()
+	defer s.space.access.Unlock()
+
+	demands, reason, err := s.spaceProblem(folder, files)
+	if err != nil || reason != "" {
+		return
+	}
+	for _, demand := range demands {
+		s.space.reserved[demand.device] += demand.bytes
+	}
+	release = func() {
+		s.space.access.Lock()
+		defer s.space.access.Unlock()
+		for _, demand := range demands {
+			s.space.reserved[demand.device] -= demand.bytes
+		}
+	}
+	return
+}
+
+// spaceProblem tells why a torrent would not fit, if so, along with the space
+// it needs on each filesystem. The caller holds the reservations lock.
+func (s *Sync) spaceProblem(folder model.Folder, files []provider.TorrentFile) (demands []spaceDemand, reason string, err error) {
+	var total, missing int64
+	for _, file := range files {
+		total += file.Length
+		missing += file.Length
+		if _, size := s.stagedFile(folder, file.Name); size <= file.Length {
+			missing -= size
+		}
+	}
+
+	if folder.Quota > 0 {
+		var used int64
+		if used, err = diskUsage(folder.LocalTempPath); err != nil {
+			return
+		}
+		if used+missing > folder.Quota {
+			reason = fmt.Sprintf("quota of %s exceeded, %s used and %s to download", formatSize(folder.Quota), formatSize(used), formatSize(missing))
+			return
+		}
+	}
+
+	tempPath := existingParent(folder.LocalTempPath)
+	postPath := existingParent(folder.LocalPostProcessingPath)
+	tempDevice, err := deviceID(tempPath)
+	if err == errSpaceUnsupported {
+		return nil, "", nil
+	}
+	if err != nil {
+		return
+	}
+	postDevice, err := deviceID(postPath)
+	if err != nil {
+		return
+	}
+	demands = []spaceDemand{{device: tempDevice, path: tempPath, bytes: missing}}
+	if copies(folder.TransferMode, postDevice == tempDevice) {
+		if postDevice == tempDevice {
+			demands[0].bytes += total
+		} else {
+			demands = append(demands, spaceDemand{device: postDevice, path: postPath, bytes: total})
+		}
+	}
+
+	for _, demand := range demands {
+		var free int64
+		if free, err = freeSpace(demand.path); err != nil {
+			return
+		}
+		available := free - s.space.reserved[demand.device] - s.transfer.MinFreeSpace
+		if demand.bytes > available {
+			if available < 0 {
+				available = 0
+			}
+			reason = fmt.Sprintf("%s needed on %s, %s available", formatSize(demand.bytes), demand.path, formatSize(available))
+			return
+		}
+	}
+	return
+}
+
+// copies tells whether files transferred to the post processing path take
+// space there. Reflinks are expected to work within a filesystem.
+func copies(mode string, sameDevice bool) bool {
+	switch mode {
+	case transferCopy:
+		return true
+	case transferHardlink, transferSymlink:
+		return false
+	}
+	return !sameDevice
+}
+
+// checkMoveSpace makes sure a file fits in the post processing path when it
+// has to be copied there.
+func (s *Sync) checkMoveSpace(mode string, oldName string, newName string) error {
+	fi, err := os.Stat(oldName)
+	if err != nil {
+		return err
+	}
+	postPath := existingParent(filepath.Dir(newName))
+	tempDevice, err := deviceID(filepath.Dir(oldName))
+	if err == errSpaceUnsupported {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	postDevice, err := deviceID(postPath)
+	if err != nil || !copies(mode, postDevice == tempDevice) {
+		return err
+	}
+	free, err := freeSpace(postPath)
+	if err != nil {
+		return err
+	}
+	if fi.Size() > free-s.transfer.MinFreeSpace {
+		return fmt.Errorf("not enough space on %s to copy %s", postPath, oldName)
+	}
+	return nil
+}
+
+func diskUsage(path string) (used int64, err error) {
+	err = filepath.Walk(path, func(name string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			used += info.Size()
+		}
+		return nil
+	})
+	return
+}
+
+// existingParent returns the path or its closest existing parent, local
+// paths being created on demand.
+func existingParent(path string) string {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !freebsd && !windows
+// +build !linux,!darwin,!freebsd,!windows
+
+package task
+
+func freeSpace(path string) (int64, error) {
+	return 0, errSpaceUnsupported
+}
+
+func deviceID(path string) (string, error) {
+	return "", errSpaceUnsupported
+}
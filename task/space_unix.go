@@ -0,0 +1,30 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package task
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+func freeSpace(path string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
+
+func deviceID(path string) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", errSpaceUnsupported
+	}
+	return fmt.Sprint(st.Dev), nil
+}
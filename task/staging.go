@@ -0,0 +1,121 @@
+package task
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"seedbox-sync/model"
+	"time"
+)
+
+// Files are downloaded under a temporary name and only get their final name
+// in the temp path once complete and validated.
+const (
+	defaultPartSuffix = ".part"
+	resumeSuffix      = ".resume"
+)
+
+// resumeState is kept next to a partial file to resume its download.
+type resumeState struct {
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"modTime,omitempty"`
+	Segments []segment `json:"segments,omitempty"`
+}
+
+// partFile returns where a file is downloaded: next to its final name with
+// a suffix, or in a sidecar directory of the temp path.
+func (s *Sync) partFile(folder model.Folder, name string) string {
+	suffix := s.transfer.PartSuffix
+	if s.transfer.PartDir != "" {
+		return folder.LocalTempPath + "/" + s.transfer.PartDir + "/" + name + suffix
+	}
+	if suffix == "" {
+		suffix = defaultPartSuffix
+	}
+	return folder.LocalTempPath + "/" + name + suffix
+}
+
+// stagedFile returns the local part of a file, partial or under its final
+// name, without changing anything.
+func (s *Sync) stagedFile(folder model.Folder, name string) (path string, size int64) {
+	partFile := s.partFile(folder, name)
+	for _, path := range []string{partFile, folder.LocalTempPath + "/" + name} {
+		if fi, err := os.Stat(path); err == nil {
+			return path, fi.Size()
+		}
+	}
+	return partFile, 0
+}
+
+func loadResumeState(partFile string) (state resumeState, err error) {
+	bytes, err := ioutil.ReadFile(partFile + resumeSuffix)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(bytes, &state)
+	return
+}
+
+func (s resumeState) save(partFile string) error {
+	bytes, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(partFile+resumeSuffix, bytes, 0644)
+}
+
+// adoptFinal turns a file found under its final name but not known as
+// downloaded, as left by older versions, back into a partial file.
+func adoptFinal(localFile string, partFile string, perms permissions) error {
+	if _, err := os.Stat(localFile); err != nil {
+		return nil
+	}
+	if _, err := os.Stat(partFile); err == nil {
+		return os.Remove(localFile)
+	}
+	if err := perms.mkdirAll(filepath.Dir(partFile)); err != nil {
+		return err
+	}
+	return os.Rename(localFile, partFile)
+}
+
+// checkPart makes sure a partial file holds the whole remote file.
+func checkPart(partFile string, remoteSize int64) error {
+	fi, err := os.Stat(partFile)
+	// Empty files have nothing to download
+	if os.IsNotExist(err) && remoteSize == 0 {
+		return ioutil.WriteFile(partFile, nil, defaultFileMode)
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Size() != remoteSize {
+		return fmt.Errorf("%s holds %d bytes instead of %d", partFile, fi.Size(), remoteSize)
+	}
+	return nil
+}
+
+// promote gives its final name, permissions and the remote modification
+// time, when known, to a complete partial file.
+func promote(partFile string, localFile string, modTime time.Time, perms permissions) error {
+	if err := perms.mkdirAll(filepath.Dir(localFile)); err != nil {
+		return err
+	}
+	if err := perms.applyFile(partFile); err != nil {
+		return err
+	}
+	if !modTime.IsZero() {
+		if err := os.Chtimes(partFile, time.Now(), modTime); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(partFile, localFile); err != nil {
+		return err
+	}
+	if err := os.Remove(partFile + resumeSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -5,28 +5,41 @@ import (
 	"io"
 	"os"
 	"path/filepath"
-	"runtime"
 	"seedbox-sync/downloader"
 	"seedbox-sync/model"
 	"seedbox-sync/notifier"
 	"seedbox-sync/provider"
+	"seedbox-sync/state"
 	"strings"
-	"syscall"
+	"sync"
+	"sync/atomic"
 )
 
 type Sync struct {
-	folders    []model.Folder
-	provider   provider.Provider
-	downloader downloader.Downloader
-	notifier   notifier.Notifier
+	folders   []model.Folder
+	transfer  model.TransferConfiguration
+	provider  provider.Provider
+	pool      *downloader.Pool
+	store     *state.Store
+	notifier  notifier.Notifier
+	output    io.Writer
+	space     *spaceReservations
+	metainfos *metainfoCache
 }
 
-func NewSync(folders []model.Folder, provider provider.Provider, downloader downloader.Downloader, notifier notifier.Notifier) *Sync {
+// NewSync writes to output the errors the notifiers do not report. Both are
+// used by concurrent transfers and must be safe for it.
+func NewSync(folders []model.Folder, transfer model.TransferConfiguration, provider provider.Provider, pool *downloader.Pool, store *state.Store, n notifier.Notifier, output io.Writer) *Sync {
 	return &Sync{
-		folders:    folders,
-		provider:   provider,
-		downloader: downloader,
-		notifier:   notifier,
+		folders:   folders,
+		transfer:  transfer,
+		provider:  provider,
+		pool:      pool,
+		store:     store,
+		notifier:  n,
+		output:    output,
+		space:     &spaceReservations{reserved: map[string]int64{}},
+		metainfos: newMetainfoCache(),
 	}
 }
 
@@ -35,161 +48,363 @@ func (s *Sync) Execute() {
 
 	torrents, err := s.provider.GetTorrents()
 	if err != nil {
-		fmt.Println("unable to retrieve the torrents")
+		fmt.Fprintln(s.output, "unable to retrieve the torrents:", err)
+		s.notifier.EndSynchro()
 		return
 	}
 
 	for _, folder := range s.folders {
+		filter, err := newFolderFilter(folder)
+		if err != nil {
+			fmt.Fprintln(s.output, "invalid rules for", folder.RemoteCompletePath, ":", err)
+			continue
+		}
+
 		s.notifier.StartFolder(folder)
+		torrentsLimit := newLimiter(s.transfer.Torrents)
 		for _, torrent := range torrents {
-			if torrent.DownloadDir == folder.RemoteCompletePath && torrent.PercentDone == 1 {
-				s.downloadTorrent(folder, torrent)
+			if filter.matches(torrent) {
+				folder, torrent := folder, torrent
+				torrentsLimit.run(func() {
+					s.downloadTorrent(folder, filter, torrent)
+				})
 			}
 		}
+		torrentsLimit.wait()
 		s.notifier.EndFolder(folder)
-		//TODO clean temp empty folders
 	}
+	s.clean(torrents, false)
 
+	s.pool.Close()
 	s.notifier.EndSynchro()
 }
 
-func (s *Sync) downloadTorrent(folder model.Folder, torrent provider.Torrent) {
+func (s *Sync) downloadTorrent(folder model.Folder, filter folderFilter, torrent provider.Torrent) {
+	// A torrent already moved locally by a previous run only misses its
+	// remote move
+	record, _ := s.store.Torrent(torrentKey(torrent))
+	if record.Status != state.StatusMoved {
+		// A torrent which does not fit is left for a later synchronisation
+		release, reason, err := s.reserveSpace(folder, filter.selectedFiles(torrent))
+		if err != nil {
+			reason = fmt.Sprint("unable to check the free space: ", err)
+		}
+		if reason != "" {
+			s.notifier.SkipTorrent(torrent, reason)
+			return
+		}
+		defer release()
+	}
+
 	s.notifier.StartTorrent(torrent)
 
-	hasError := false
-	for _, file := range torrent.Files {
-		if file.IsCompleted() {
-			e := s.downloadFile(folder, file)
-			hasError = hasError || e != nil
+	var tx *transaction
+	if record.Status != state.StatusMoved {
+		s.recordTorrent(folder, torrent, state.StatusDownloading)
+
+		var hasError int32
+		filesLimit := newLimiter(s.transfer.Files)
+		for _, file := range filter.selectedFiles(torrent) {
+			file := file
+			filesLimit.run(func() {
+				if e := s.downloadFile(folder, torrent, file); e != nil {
+					atomic.StoreInt32(&hasError, 1)
+				}
+			})
+		}
+		filesLimit.wait()
+
+		// Files synchronised early wait in the temp path for the rest
+		if atomic.LoadInt32(&hasError) != 0 || !torrent.IsCompleted() {
+			s.notifier.EndTorrent(torrent)
+			return
+		}
+		if folder.Verify && !s.verify(folder, filter, torrent) {
+			s.notifier.EndTorrent(torrent)
+			return
+		}
+		s.recordTorrent(folder, torrent, state.StatusDownloaded)
+
+		tx = &transaction{mode: folder.TransferMode, perms: s.permissions(folder)}
+		defer s.commit(tx, torrent)
+		moved, err := s.moveFiles(tx, folder, filter, torrent)
+		if err != nil {
+			fmt.Fprintln(s.output, "unable to move", torrent.Name, ":", err)
+			s.rollback(tx, torrent)
+			s.notifier.EndTorrent(torrent)
+			return
+		}
+		s.recordTorrent(folder, torrent, state.StatusMoved)
+		if folder.Extract.Enabled {
+			s.extractArchives(tx, folder, filter, torrent, moved)
 		}
 	}
 
-	if !hasError {
-		for _, file := range torrent.Files {
-			err := s.moveFile(folder, file)
-			if err != nil {
-				return
-			}
+	if err := s.provider.SetLocation(torrent, folder.RemoteSharePath); err != nil {
+		fmt.Fprintln(s.output, "unable to set the location of", torrent.Name, ":", err)
+		// Without persistent state the remote move could not be retried on
+		// its own, so put the files back where the next run expects them
+		if tx != nil && !s.store.IsPersistent() && s.rollback(tx, torrent) {
+			s.recordTorrent(folder, torrent, state.StatusDownloaded)
 		}
-		_ = s.provider.SetLocation(torrent, folder.RemoteSharePath)
-		//TODO revert move if setlocation failed
+		s.notifier.EndTorrent(torrent)
+		return
 	}
+	s.recordTorrent(folder, torrent, state.StatusSynced)
+	s.deleteExcluded(folder, filter, torrent)
 
 	s.notifier.EndTorrent(torrent)
 }
 
-func (s *Sync) downloadFile(folder model.Folder, file provider.TorrentFile) error {
+func (s *Sync) downloadFile(folder model.Folder, torrent provider.Torrent, file provider.TorrentFile) (err error) {
 	s.notifier.StartFile(file)
+	defer func() {
+		if err != nil {
+			s.notifier.EndFile(file, false)
+		}
+	}()
 
-	d := s.downloader
-	root := d.GetRoot()
-	remotePath := strings.Replace(folder.RemoteCompletePath, root, "", 1)
-	remoteFile := remotePath + "/" + file.Name
 	localFile := folder.LocalTempPath + "/" + file.Name
+	partFile := s.partFile(folder, file.Name)
 
-	fi, err := os.Stat(localFile)
+	// Nothing to do for a file already downloaded by a previous run
+	record, _ := s.store.Torrent(torrentKey(torrent))
+	if f, ok := record.Files[file.Name]; ok && f.Status == state.StatusDownloaded {
+		if fi, err := os.Stat(localFile); err == nil && f.Bytes == fi.Size() {
+			s.notifier.EndFile(file, true)
+			return nil
+		}
+	}
+	perms := s.permissions(folder)
+	if err = adoptFinal(localFile, partFile, perms); err != nil {
+		return err
+	}
+
+	fi, err := os.Stat(partFile)
 	var localSize int64 = 0
 	if err == nil {
 		localSize = fi.Size()
 	}
 
-	if localSize == 0 {
-		parent := filepath.Dir(localFile)
-		_ = os.MkdirAll(parent, 0755)
+	d, err := s.pool.Get()
+	if err != nil {
+		return err
 	}
+	root := d.GetRoot()
+	remotePath := strings.Replace(folder.RemoteCompletePath, root, "", 1)
+	remoteFile := remotePath + "/" + file.Name
 
 	remoteSize, err := d.GetRemoteSize(file, folder.RemoteCompletePath)
 	if err != nil {
+		s.pool.Discard(d)
 		return err
 	}
-	if localSize < remoteSize {
-		reader, err := s.downloader.GetFile(remoteFile, uint64(localSize))
-		if err != nil {
+	modTime := remoteModTime(d, file, folder.RemoteCompletePath)
+	s.pool.Put(d)
+
+	if localSize > 0 {
+		if localSize, err = s.checkResume(record, file, remoteFile, partFile, localSize, remoteSize, modTime); err != nil {
 			return err
 		}
-		proxyReader := &ProxyReader{
-			value:    localSize,
-			file:     file,
-			reader:   reader,
-			notifier: s.notifier,
+	}
+	if localSize == 0 {
+		if err = perms.mkdirAll(filepath.Dir(partFile)); err != nil {
+			return err
 		}
+	}
 
-		open, err := os.OpenFile(localFile, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0755)
+	if s.isSegmented(partFile, localSize, remoteSize) {
+		s.recordFile(torrent, file.Name, state.File{
+			Status:  state.StatusDownloading,
+			Bytes:   remoteSize,
+			ModTime: modTime,
+		})
+		err = s.downloadSegments(file, remoteFile, partFile, localSize, remoteSize, modTime)
 		if err != nil {
 			return err
 		}
-
-		_, err = io.Copy(open, proxyReader)
-
-		defer proxyReader.Close()
-		defer open.Close()
-
+	} else if localSize < remoteSize {
+		s.recordFile(torrent, file.Name, state.File{
+			Status:  state.StatusDownloading,
+			Bytes:   remoteSize,
+			ModTime: modTime,
+		})
+		if err = (resumeState{Size: remoteSize, ModTime: modTime}).save(partFile); err != nil {
+			return err
+		}
+		if d, err = s.pool.Get(); err != nil {
+			return err
+		}
+		err = s.downloadStream(d, file, remoteFile, partFile, localSize)
 		if err != nil {
+			s.pool.Discard(d)
+			return err
+		}
+		s.pool.Put(d)
+	}
+
+	if err = checkPart(partFile, remoteSize); err != nil {
+		return err
+	}
+	if folder.Verify {
+		if err = s.verifyPart(folder, torrent, file, partFile); err != nil {
 			return err
 		}
 	}
+	checksum, err := fileChecksum(partFile)
+	if err != nil {
+		return err
+	}
+	if err = promote(partFile, localFile, modTime, perms); err != nil {
+		return err
+	}
+	s.recordFile(torrent, file.Name, state.File{
+		Status:   state.StatusDownloaded,
+		Bytes:    remoteSize,
+		Checksum: checksum,
+		ModTime:  modTime,
+	})
+
 	s.notifier.EndFile(file, true)
 	return nil
 }
 
-func (s *Sync) moveFile(folder model.Folder, file provider.TorrentFile) (err error) {
-	//TODO check available space before move a file
-	oldName := folder.LocalTempPath + "/" + file.Name
-	newName := folder.LocalPostProcessingPath + "/" + file.Name
+func (s *Sync) downloadStream(d downloader.Downloader, file provider.TorrentFile, remoteFile string, localFile string, localSize int64) error {
+	reader, err := d.GetFile(remoteFile, uint64(localSize))
+	if err != nil {
+		return err
+	}
+	proxyReader := &ProxyReader{
+		reader:   reader,
+		progress: newFileProgress(file, localSize, s.notifier),
+	}
+	defer proxyReader.Close()
 
-	parent := filepath.Dir(newName)
-	_ = os.MkdirAll(parent, 0755)
-	err = os.Rename(oldName, newName)
-	le, ok := err.(*os.LinkError)
-	if !ok {
+	open, err := os.OpenFile(localFile, os.O_RDWR|os.O_CREATE|os.O_APPEND, defaultFileMode)
+	if err != nil {
 		return err
 	}
-	// 0x11 is Win32 Error Code ERROR_NOT_SAME_DEVICE (https://msdn.microsoft.com/en-us/library/cc231199.aspx)
-	if le.Err == syscall.Errno(0x12) || (runtime.GOOS == "windows" && le.Err == syscall.Errno(0x11)) {
-		err = moveFile(oldName, newName)
+	defer open.Close()
+
+	_, err = io.Copy(open, proxyReader)
+	return err
+}
+
+func (s *Sync) verify(folder model.Folder, filter folderFilter, torrent provider.Torrent) bool {
+	repaired, err := s.verifyTorrent(torrent, filter.selectedFiles(torrent), folder.LocalTempPath, folder.RemoteCompletePath)
+	if isUnverifiable(err) {
+		s.notifier.Unverified(torrent, err.Error())
+		return true
+	}
+	if err != nil {
+		fmt.Fprintln(s.output, "unable to verify", torrent.Name, ":", err)
+		return false
+	}
+	for _, name := range repaired {
+		fmt.Fprintln(s.output, "repaired", name, "of", torrent.Name)
+		localFile := folder.LocalTempPath + "/" + name
+		checksum, err := fileChecksum(localFile)
 		if err != nil {
-			return err
+			fmt.Fprintln(s.output, "unable to verify", torrent.Name, ":", err)
+			return false
+		}
+		fi, err := os.Stat(localFile)
+		if err != nil {
+			fmt.Fprintln(s.output, "unable to verify", torrent.Name, ":", err)
+			return false
 		}
+		s.recordFile(torrent, name, state.File{
+			Status:   state.StatusDownloaded,
+			Bytes:    fi.Size(),
+			Checksum: checksum,
+		})
 	}
-	return
+	return true
+}
 
+// moveFiles returns where each file was moved, the skipped ones being left
+// out.
+func (s *Sync) moveFiles(tx *transaction, folder model.Folder, filter folderFilter, torrent provider.Torrent) (moved map[string]string, err error) {
+	// Make sure every file is there, and may be moved, before moving anything
+	files := filter.selectedFiles(torrent)
+	decisions := make([]string, len(files))
+	dests := make([]string, len(files))
+	for i, file := range files {
+		oldName := folder.LocalTempPath + "/" + file.Name
+		fi, err := os.Stat(oldName)
+		if err != nil {
+			return nil, err
+		}
+		decision, dest, err := resolveConflict(folder.Conflict, fi.Size(), folder.LocalPostProcessingPath+"/"+file.Name)
+		if err != nil {
+			return nil, err
+		}
+		if decision != "" {
+			s.notifier.Conflict(file, dest, decision)
+		}
+		if decision == conflictFail {
+			return nil, fmt.Errorf("%s already exists", dest)
+		}
+		decisions[i], dests[i] = decision, dest
+	}
+	moved = map[string]string{}
+	for i, file := range files {
+		if decisions[i] == conflictSkip {
+			continue
+		}
+		if decisions[i] == conflictOverwrite {
+			if err = tx.replace(dests[i]); err != nil {
+				return
+			}
+		}
+		if err = s.moveFile(tx, folder, folder.LocalTempPath+"/"+file.Name, dests[i]); err != nil {
+			return
+		}
+		moved[file.Name] = dests[i]
+	}
+	return
 }
 
-func moveFile(sourcePath, destPath string) error {
-	inputFile, err := os.Open(sourcePath)
-	if err != nil {
-		return fmt.Errorf("couldn't open source file: %s", err)
+func (s *Sync) moveFile(tx *transaction, folder model.Folder, oldName string, newName string) (err error) {
+	if err = s.checkMoveSpace(folder.TransferMode, oldName, newName); err != nil {
+		return
 	}
-	outputFile, err := os.Create(destPath)
-	if err != nil {
-		inputFile.Close()
-		return fmt.Errorf("couldn't open dest file: %s", err)
+	return tx.move(oldName, newName)
+}
+
+func (s *Sync) deleteExcluded(folder model.Folder, filter folderFilter, torrent provider.Torrent) {
+	for _, file := range filter.excludedFiles(torrent) {
+		partFile := s.partFile(folder, file.Name)
+		for _, name := range []string{folder.LocalTempPath + "/" + file.Name, partFile, partFile + resumeSuffix} {
+			if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+				fmt.Fprintln(s.output, "unable to delete", name, ":", err)
+			}
+		}
 	}
-	defer outputFile.Close()
-	_, err = io.Copy(outputFile, inputFile)
-	inputFile.Close()
-	if err != nil {
-		return fmt.Errorf("writing to output file failed: %s", err)
+}
+
+func (s *Sync) commit(tx *transaction, torrent provider.Torrent) {
+	if err := tx.commit(); err != nil {
+		fmt.Fprintln(s.output, "unable to delete the files replaced by", torrent.Name, ":", err)
 	}
-	// The copy was successful, so now delete the original file
-	err = os.Remove(sourcePath)
-	if err != nil {
-		return fmt.Errorf("failed removing original file: %s", err)
+}
+
+func (s *Sync) rollback(tx *transaction, torrent provider.Torrent) bool {
+	if err := tx.rollback(); err != nil {
+		fmt.Fprintln(s.output, "unable to revert the move of", torrent.Name, ":", err)
+		return false
 	}
-	return nil
+	return true
 }
 
 type ProxyReader struct {
-	value    int64
-	file     provider.TorrentFile
 	reader   io.Reader
-	notifier notifier.Notifier
+	progress *fileProgress
 }
 
 func (r *ProxyReader) Read(p []byte) (n int, err error) {
 	n, err = r.reader.Read(p)
-	r.value += int64(n)
-	r.notifier.ProgressFile(r.file, r.value, r.file.Length)
+	r.progress.add(int64(n))
 	return
 }
 
@@ -199,3 +414,27 @@ func (r *ProxyReader) Close() (err error) {
 	}
 	return
 }
+
+// fileProgress aggregates the bytes received for a file, possibly by several
+// readers at once, into a single progress stream for the notifier.
+type fileProgress struct {
+	value    int64
+	file     provider.TorrentFile
+	notifier notifier.Notifier
+	access   sync.Mutex
+}
+
+func newFileProgress(file provider.TorrentFile, value int64, notifier notifier.Notifier) *fileProgress {
+	return &fileProgress{
+		value:    value,
+		file:     file,
+		notifier: notifier,
+	}
+}
+
+func (p *fileProgress) add(n int64) {
+	defer p.access.Unlock()
+	p.access.Lock()
+	p.value += n
+	p.notifier.ProgressFile(p.file, p.value, p.file.Length)
+}
@@ -0,0 +1,334 @@
+package task
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"seedbox-sync/downloader"
+	"seedbox-sync/model"
+	"seedbox-sync/notifier"
+	"seedbox-sync/provider"
+	"seedbox-sync/state"
+	"sync"
+	"testing"
+)
+
+const (
+	testRoot         = "/seed"
+	testCompletePath = testRoot + "/complete"
+	testSharePath    = testRoot + "/share"
+)
+
+// fakeProvider lists fixed torrents and records where they are moved.
+type fakeProvider struct {
+	torrents  []provider.Torrent
+	locations []string
+	err       error
+	access    sync.Mutex
+}
+
+func (p *fakeProvider) GetTorrents() ([]provider.Torrent, error) {
+	return p.torrents, nil
+}
+
+func (p *fakeProvider) SetLocation(torrent provider.Torrent, remoteSharePath string) error {
+	p.access.Lock()
+	defer p.access.Unlock()
+	p.locations = append(p.locations, torrent.Hash+":"+remoteSharePath)
+	return p.err
+}
+
+// syncTest synchronises torrents from a mounted remote with the local
+// downloader.
+type syncTest struct {
+	t        *testing.T
+	mount    string
+	folder   model.Folder
+	transfer model.TransferConfiguration
+	provider *fakeProvider
+	store    *state.Store
+}
+
+func newSyncTest(t *testing.T) *syncTest {
+	dir := t.TempDir()
+	store, err := state.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &syncTest{
+		t:     t,
+		mount: filepath.Join(dir, "mount"),
+		folder: model.Folder{
+			RemoteCompletePath:      testCompletePath,
+			RemoteSharePath:         testSharePath,
+			LocalTempPath:           filepath.Join(dir, "temp"),
+			LocalPostProcessingPath: filepath.Join(dir, "post"),
+		},
+		provider: &fakeProvider{},
+		store:    store,
+	}
+}
+
+// addTorrent writes the files of a completed torrent in the remote complete
+// path.
+func (st *syncTest) addTorrent(hash string, contents map[string][]byte) provider.Torrent {
+	torrent := provider.Torrent{
+		Hash:        hash,
+		Name:        hash,
+		PercentDone: 1,
+		DownloadDir: testCompletePath,
+	}
+	for name, content := range contents {
+		writeTestFile(st.t, filepath.Join(st.mount, "complete", name), content)
+		torrent.Files = append(torrent.Files, provider.TorrentFile{
+			Name:           name,
+			Length:         int64(len(content)),
+			BytesCompleted: int64(len(content)),
+			Wanted:         true,
+		})
+	}
+	st.provider.torrents = append(st.provider.torrents, torrent)
+	return torrent
+}
+
+// pool hands out local downloaders reading the remote files from the mount.
+func (st *syncTest) pool() *downloader.Pool {
+	return downloader.NewPool(func() (downloader.Downloader, error) {
+		return downloader.NewLocal(st.mount, testRoot), nil
+	}, 4)
+}
+
+func (st *syncTest) execute() {
+	NewSync([]model.Folder{st.folder}, st.transfer, st.provider, st.pool(), st.store, notifier.NewCompose(), os.Stdout).Execute()
+}
+
+func (st *syncTest) status(hash string) string {
+	record, _ := st.store.Torrent(hash)
+	return record.Status
+}
+
+func writeTestFile(t *testing.T, name string, content []byte) {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(name, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func randomContent(t *testing.T, size int) []byte {
+	content := make([]byte, size)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func assertContent(t *testing.T, name string, want []byte) {
+	t.Helper()
+	content, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !bytes.Equal(content, want) {
+		t.Errorf("%s holds %d bytes different from the %d expected", name, len(content), len(want))
+	}
+}
+
+func assertMissing(t *testing.T, name string) {
+	t.Helper()
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("%s should not exist: %v", name, err)
+	}
+}
+
+func TestSyncExecute(t *testing.T) {
+	st := newSyncTest(t)
+	movie := randomContent(t, 100<<10)
+	st.addTorrent("abcd", map[string][]byte{
+		"Movie/a.mkv":     movie,
+		"Movie/sub/b.nfo": []byte("info"),
+	})
+
+	st.execute()
+
+	assertContent(t, filepath.Join(st.folder.LocalPostProcessingPath, "Movie/a.mkv"), movie)
+	assertContent(t, filepath.Join(st.folder.LocalPostProcessingPath, "Movie/sub/b.nfo"), []byte("info"))
+	assertMissing(t, filepath.Join(st.folder.LocalTempPath, "Movie/a.mkv"))
+	assertMissing(t, filepath.Join(st.folder.LocalTempPath, "Movie/a.mkv"+defaultPartSuffix))
+	if want := []string{"abcd:" + testSharePath}; !reflect.DeepEqual(st.provider.locations, want) {
+		t.Errorf("locations = %v, want %v", st.provider.locations, want)
+	}
+	if status := st.status("abcd"); status != state.StatusSynced {
+		t.Errorf("status = %s, want %s", status, state.StatusSynced)
+	}
+}
+
+func TestSyncResume(t *testing.T) {
+	st := newSyncTest(t)
+	movie := randomContent(t, 400<<10)
+	st.addTorrent("abcd", map[string][]byte{"Movie/a.mkv": movie})
+
+	// The start of the part file differs from the remote one but its end
+	// matches: only the missing bytes are downloaded
+	part := append(make([]byte, 100<<10), movie[100<<10:200<<10]...)
+	writeTestFile(t, filepath.Join(st.folder.LocalTempPath, "Movie/a.mkv"+defaultPartSuffix), part)
+
+	st.execute()
+
+	want := append(append([]byte{}, part...), movie[len(part):]...)
+	assertContent(t, filepath.Join(st.folder.LocalPostProcessingPath, "Movie/a.mkv"), want)
+}
+
+func TestSyncRestart(t *testing.T) {
+	st := newSyncTest(t)
+	movie := randomContent(t, 400<<10)
+	st.addTorrent("abcd", map[string][]byte{"Movie/a.mkv": movie})
+
+	// A part file whose end differs belongs to another file
+	writeTestFile(t, filepath.Join(st.folder.LocalTempPath, "Movie/a.mkv"+defaultPartSuffix), make([]byte, 200<<10))
+
+	st.execute()
+
+	assertContent(t, filepath.Join(st.folder.LocalPostProcessingPath, "Movie/a.mkv"), movie)
+}
+
+func TestSyncSegmented(t *testing.T) {
+	st := newSyncTest(t)
+	st.transfer = model.TransferConfiguration{Segments: 4, SegmentThreshold: 1}
+	movie := randomContent(t, 1<<20+3)
+	st.addTorrent("abcd", map[string][]byte{"Movie/a.mkv": movie})
+
+	st.execute()
+
+	assertContent(t, filepath.Join(st.folder.LocalPostProcessingPath, "Movie/a.mkv"), movie)
+	assertMissing(t, filepath.Join(st.folder.LocalTempPath, "Movie/a.mkv"+defaultPartSuffix+resumeSuffix))
+}
+
+func TestSyncSegmentedResume(t *testing.T) {
+	st := newSyncTest(t)
+	st.transfer = model.TransferConfiguration{Segments: 4, SegmentThreshold: 1}
+	movie := randomContent(t, 1<<20)
+	st.addTorrent("abcd", map[string][]byte{"Movie/a.mkv": movie})
+	fi, err := os.Stat(filepath.Join(st.mount, "complete", "Movie/a.mkv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first segment and half of the third are done, with other bytes
+	// than the remote ones to tell them apart
+	partFile := filepath.Join(st.folder.LocalTempPath, "Movie/a.mkv"+defaultPartSuffix)
+	part := make([]byte, len(movie))
+	done := bytes.Repeat([]byte{0xaa}, 256<<10)
+	copy(part, done)
+	copy(part[512<<10:], done[:128<<10])
+	writeTestFile(t, partFile, part)
+	resume := resumeState{
+		Size:    int64(len(movie)),
+		ModTime: fi.ModTime(),
+		Segments: []segment{
+			{Start: 0, End: 256 << 10, Done: 256 << 10},
+			{Start: 256 << 10, End: 512 << 10},
+			{Start: 512 << 10, End: 768 << 10, Done: 128 << 10},
+			{Start: 768 << 10, End: 1 << 20},
+		},
+	}
+	if err = resume.save(partFile); err != nil {
+		t.Fatal(err)
+	}
+
+	st.execute()
+
+	want := append([]byte{}, movie...)
+	copy(want, done)
+	copy(want[512<<10:], done[:128<<10])
+	assertContent(t, filepath.Join(st.folder.LocalPostProcessingPath, "Movie/a.mkv"), want)
+}
+
+func TestSyncRollback(t *testing.T) {
+	st := newSyncTest(t)
+	st.folder.Conflict = conflictOverwrite
+	st.provider.err = errors.New("move refused")
+	movie := randomContent(t, 10<<10)
+	st.addTorrent("abcd", map[string][]byte{
+		"Movie/a.mkv":     movie,
+		"Movie/sub/b.nfo": []byte("info"),
+	})
+	existing := filepath.Join(st.folder.LocalPostProcessingPath, "Movie/sub/b.nfo")
+	writeTestFile(t, existing, []byte("previous"))
+
+	st.execute()
+
+	// Without persistent state, the files are put back in the temp path and
+	// the overwritten one is restored
+	assertContent(t, filepath.Join(st.folder.LocalTempPath, "Movie/a.mkv"), movie)
+	assertContent(t, filepath.Join(st.folder.LocalTempPath, "Movie/sub/b.nfo"), []byte("info"))
+	assertMissing(t, filepath.Join(st.folder.LocalPostProcessingPath, "Movie/a.mkv"))
+	assertContent(t, existing, []byte("previous"))
+	assertMissing(t, existing+replacedSuffix)
+	if status := st.status("abcd"); status != state.StatusDownloaded {
+		t.Errorf("status = %s, want %s", status, state.StatusDownloaded)
+	}
+
+	// The next run moves them again
+	st.provider.err = nil
+	st.execute()
+	assertContent(t, filepath.Join(st.folder.LocalPostProcessingPath, "Movie/a.mkv"), movie)
+	assertContent(t, existing, []byte("info"))
+	if status := st.status("abcd"); status != state.StatusSynced {
+		t.Errorf("status = %s, want %s", status, state.StatusSynced)
+	}
+}
+
+func TestSyncRetryLocation(t *testing.T) {
+	st := newSyncTest(t)
+	store, err := state.Open(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.store = store
+	st.provider.err = errors.New("move refused")
+	movie := randomContent(t, 10<<10)
+	st.addTorrent("abcd", map[string][]byte{"Movie/a.mkv": movie})
+
+	// With persistent state, the local move is kept and only the remote one
+	// is retried
+	st.execute()
+	assertContent(t, filepath.Join(st.folder.LocalPostProcessingPath, "Movie/a.mkv"), movie)
+	if status := st.status("abcd"); status != state.StatusMoved {
+		t.Errorf("status = %s, want %s", status, state.StatusMoved)
+	}
+
+	st.provider.err = nil
+	if err = os.Remove(filepath.Join(st.mount, "complete", "Movie/a.mkv")); err != nil {
+		t.Fatal(err)
+	}
+	st.execute()
+	if len(st.provider.locations) != 2 {
+		t.Errorf("locations = %v, want 2 attempts", st.provider.locations)
+	}
+	if status := st.status("abcd"); status != state.StatusSynced {
+		t.Errorf("status = %s, want %s", status, state.StatusSynced)
+	}
+}
+
+func TestSyncSkipsIncomplete(t *testing.T) {
+	st := newSyncTest(t)
+	torrent := st.addTorrent("abcd", map[string][]byte{"Movie/a.mkv": []byte("partial")})
+	torrent.Files[0].BytesCompleted = 3
+	torrent.PercentDone = 0.5
+	st.provider.torrents[0] = torrent
+
+	st.execute()
+
+	assertMissing(t, filepath.Join(st.folder.LocalTempPath, "Movie/a.mkv"))
+	assertMissing(t, filepath.Join(st.folder.LocalPostProcessingPath, "Movie/a.mkv"))
+	if len(st.provider.locations) != 0 {
+		t.Errorf("locations = %v, want none", st.provider.locations)
+	}
+}
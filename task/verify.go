@@ -0,0 +1,405 @@
+package task
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"seedbox-sync/downloader"
+	"seedbox-sync/metainfo"
+	"seedbox-sync/model"
+	"seedbox-sync/notifier"
+	"seedbox-sync/provider"
+	"seedbox-sync/state"
+	"strings"
+	"sync"
+)
+
+// Verify checks already synchronised torrents against their piece hashes,
+// downloading again the corrupted parts.
+type Verify struct {
+	sync *Sync
+	hash string
+}
+
+func NewVerify(folders []model.Folder, transfer model.TransferConfiguration, provider provider.Provider, pool *downloader.Pool, store *state.Store, hash string) *Verify {
+	return &Verify{
+		sync: NewSync(folders, transfer, provider, pool, store, notifier.NewCompose(), os.Stdout),
+		hash: hash,
+	}
+}
+
+func (v *Verify) Execute() {
+	defer v.sync.pool.Close()
+
+	torrents, err := v.sync.provider.GetTorrents()
+	if err != nil {
+		fmt.Fprintln(v.sync.output, "unable to retrieve the torrents:", err)
+		return
+	}
+
+	for _, folder := range v.sync.folders {
+		filter, err := newFolderFilter(folder)
+		if err != nil {
+			fmt.Fprintln(v.sync.output, "invalid rules for", folder.RemoteCompletePath, ":", err)
+			continue
+		}
+		for _, torrent := range torrents {
+			if torrent.DownloadDir != folder.RemoteSharePath || !filter.matchesRules(torrent) {
+				continue
+			}
+			if v.hash != "" && !strings.EqualFold(v.hash, torrent.Hash) {
+				continue
+			}
+			repaired, err := v.sync.verifyTorrent(torrent, filter.selectedFiles(torrent), folder.LocalPostProcessingPath, folder.RemoteSharePath)
+			switch {
+			case isUnverifiable(err):
+				fmt.Fprintln(v.sync.output, "SKIPPED ", torrent.Name, ":", err)
+			case err != nil:
+				fmt.Fprintln(v.sync.output, "FAILED  ", torrent.Name, ":", err)
+			case len(repaired) > 0:
+				fmt.Fprintln(v.sync.output, "REPAIRED", torrent.Name, ":", strings.Join(repaired, ", "))
+			default:
+				fmt.Fprintln(v.sync.output, "OK      ", torrent.Name)
+			}
+		}
+	}
+}
+
+// verifyTorrent checks the pieces of the files present in localPath and
+// downloads again the failing ranges from remotePath. Pieces overlapping
+// files which are not there cannot be checked and are ignored.
+func (s *Sync) verifyTorrent(torrent provider.Torrent, files []provider.TorrentFile, localPath string, remotePath string) (repaired []string, err error) {
+	m, err := s.loadMetainfo(torrent)
+	if err != nil {
+		return
+	}
+	present := map[string]bool{}
+	for _, file := range files {
+		if _, e := os.Stat(localPath + "/" + file.Name); e == nil {
+			present[file.Name] = true
+		}
+	}
+	path := func(name string) string {
+		return localPath + "/" + name
+	}
+
+	layout := newPieceLayout(m)
+	ranges, err := s.repairPieces(layout, path, present, layout.all(), remotePath)
+	if err != nil {
+		return
+	}
+	seen := map[string]bool{}
+	for _, r := range ranges {
+		if !seen[r.name] {
+			seen[r.name] = true
+			repaired = append(repaired, r.name)
+		}
+	}
+	return
+}
+
+// verifyPart checks the pieces lying entirely in a downloaded file before it
+// gets its final name. Pieces shared with other files are left to the check
+// of the whole torrent.
+func (s *Sync) verifyPart(folder model.Folder, torrent provider.Torrent, file provider.TorrentFile, partFile string) error {
+	m, err := s.loadMetainfo(torrent)
+	if isUnverifiable(err) {
+		// Reported once the whole torrent is verified
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	path := func(name string) string {
+		return partFile
+	}
+	layout := newPieceLayout(m)
+	ranges, err := s.repairPieces(layout, path, map[string]bool{file.Name: true}, layout.piecesOf(file.Name), folder.RemoteCompletePath)
+	if err == nil && len(ranges) > 0 {
+		fmt.Fprintln(s.output, "repaired", file.Name, "of", torrent.Name)
+	}
+	return err
+}
+
+// repairPieces downloads again the ranges of the failing pieces among the
+// given ones, path telling where each file lies locally.
+func (s *Sync) repairPieces(layout pieceLayout, path func(string) string, present map[string]bool, pieces []int, remotePath string) (ranges []fileRange, err error) {
+	failing, err := layout.check(path, present, pieces)
+	if err != nil || len(failing) == 0 {
+		return
+	}
+
+	ranges = layout.ranges(failing)
+	for _, r := range ranges {
+		if err = s.repairRange(r, path(r.name), remotePath); err != nil {
+			return
+		}
+	}
+	if failing, err = layout.check(path, present, failing); err != nil {
+		return
+	}
+	if len(failing) > 0 {
+		err = fmt.Errorf("%d pieces still corrupted after repair", len(failing))
+	}
+	return
+}
+
+// metainfoCache keeps the parsed .torrent files, needed for each file when
+// verifying them as they are downloaded, and why the unverifiable torrents
+// cannot be verified.
+type metainfoCache struct {
+	metainfos map[string]metainfo.Metainfo
+	missing   map[string]error
+	loading   map[string]*sync.Mutex
+	access    sync.Mutex
+}
+
+func newMetainfoCache() *metainfoCache {
+	return &metainfoCache{
+		metainfos: map[string]metainfo.Metainfo{},
+		missing:   map[string]error{},
+		loading:   map[string]*sync.Mutex{},
+	}
+}
+
+// unverifiable is returned when a torrent has no metainfo to check it
+// against: its verification is then skipped rather than failing its download.
+type unverifiable struct {
+	reason error
+}
+
+func (e unverifiable) Error() string {
+	return "no metainfo to verify against: " + e.reason.Error()
+}
+
+func isUnverifiable(err error) bool {
+	_, ok := err.(unverifiable)
+	return ok
+}
+
+// loadMetainfo asks the provider for the .torrent file or downloads it. Only
+// the torrents which cannot be verified are remembered, other failures being
+// retried by the next call.
+func (s *Sync) loadMetainfo(torrent provider.Torrent) (m metainfo.Metainfo, err error) {
+	// The files of a torrent wait for the same load, other torrents do not
+	s.metainfos.access.Lock()
+	loading, ok := s.metainfos.loading[torrent.Hash]
+	if !ok {
+		loading = &sync.Mutex{}
+		s.metainfos.loading[torrent.Hash] = loading
+	}
+	s.metainfos.access.Unlock()
+	defer loading.Unlock()
+	loading.Lock()
+
+	s.metainfos.access.Lock()
+	m, ok = s.metainfos.metainfos[torrent.Hash]
+	missing, isMissing := s.metainfos.missing[torrent.Hash]
+	s.metainfos.access.Unlock()
+	if ok {
+		return
+	}
+	if isMissing {
+		err = missing
+		return
+	}
+
+	var data []byte
+	if exporter, ok := s.provider.(provider.MetainfoExporter); ok {
+		data, err = exporter.ExportMetainfo(torrent)
+	} else if torrent.MetainfoPath != "" {
+		if data, err = s.fetch(torrent.MetainfoPath); err != nil {
+			err = fmt.Errorf("unable to download the metainfo: %v", err)
+		}
+	} else {
+		err = provider.ErrNoMetainfo
+	}
+	if err == nil {
+		m, err = metainfo.Parse(data)
+	}
+
+	s.metainfos.access.Lock()
+	defer s.metainfos.access.Unlock()
+	switch err {
+	case nil:
+		s.metainfos.metainfos[torrent.Hash] = m
+	case provider.ErrNoMetainfo, metainfo.ErrNoPieceHashes:
+		err = unverifiable{reason: err}
+		s.metainfos.missing[torrent.Hash] = err
+	}
+	return
+}
+
+func (s *Sync) fetch(remotePath string) (data []byte, err error) {
+	err = s.readRemote(func(root string) string {
+		return strings.Replace(remotePath, root, "", 1)
+	}, 0, func(reader io.Reader) (err error) {
+		data, err = ioutil.ReadAll(reader)
+		return
+	})
+	return
+}
+
+func (s *Sync) repairRange(r fileRange, localFile string, remotePath string) error {
+	open, err := os.OpenFile(localFile, os.O_WRONLY, defaultFileMode)
+	if err != nil {
+		return err
+	}
+	err = s.readRemote(func(root string) string {
+		return strings.Replace(remotePath, root, "", 1) + "/" + r.name
+	}, r.offset, func(reader io.Reader) (err error) {
+		if _, err = open.Seek(r.offset, io.SeekStart); err == nil {
+			_, err = io.CopyN(open, reader, r.length)
+		}
+		return
+	})
+	if closeErr := open.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// pieceLayout maps the pieces of a torrent onto its files.
+type pieceLayout struct {
+	metainfo metainfo.Metainfo
+	offsets  []int64
+	length   int64
+}
+
+type fileSpan struct {
+	file   metainfo.File
+	offset int64
+	length int64
+}
+
+type fileRange struct {
+	name   string
+	offset int64
+	length int64
+}
+
+func newPieceLayout(m metainfo.Metainfo) pieceLayout {
+	l := pieceLayout{metainfo: m}
+	for _, file := range m.Files {
+		l.offsets = append(l.offsets, l.length)
+		l.length += file.Length
+	}
+	return l
+}
+
+func (l pieceLayout) all() []int {
+	pieces := make([]int, len(l.metainfo.Pieces))
+	for i := range pieces {
+		pieces[i] = i
+	}
+	return pieces
+}
+
+func (l pieceLayout) spans(piece int) (spans []fileSpan) {
+	start := int64(piece) * l.metainfo.PieceLength
+	end := start + l.metainfo.PieceLength
+	if end > l.length {
+		end = l.length
+	}
+	for i, file := range l.metainfo.Files {
+		fileStart := l.offsets[i]
+		fileEnd := fileStart + file.Length
+		if fileEnd <= start || fileStart >= end {
+			continue
+		}
+		from := start
+		if fileStart > from {
+			from = fileStart
+		}
+		to := end
+		if fileEnd < to {
+			to = fileEnd
+		}
+		spans = append(spans, fileSpan{file: file, offset: from - fileStart, length: to - from})
+	}
+	return
+}
+
+// piecesOf returns the pieces overlapping a file.
+func (l pieceLayout) piecesOf(name string) (pieces []int) {
+	for i, file := range l.metainfo.Files {
+		if file.Name != name || file.Length == 0 {
+			continue
+		}
+		first := l.offsets[i] / l.metainfo.PieceLength
+		last := (l.offsets[i] + file.Length - 1) / l.metainfo.PieceLength
+		for piece := first; piece <= last; piece++ {
+			pieces = append(pieces, int(piece))
+		}
+	}
+	return
+}
+
+// check returns the pieces among the given ones whose hash does not match.
+func (l pieceLayout) check(path func(string) string, present map[string]bool, pieces []int) (failing []int, err error) {
+	opened := map[string]*os.File{}
+	defer func() {
+		for _, f := range opened {
+			f.Close()
+		}
+	}()
+
+	for _, piece := range pieces {
+		spans := l.spans(piece)
+		checkable := true
+		for _, span := range spans {
+			if !span.file.Padding && !present[span.file.Name] {
+				checkable = false
+				break
+			}
+		}
+		if !checkable {
+			continue
+		}
+
+		h := sha1.New()
+		complete := true
+		for _, span := range spans {
+			buf := make([]byte, span.length)
+			if !span.file.Padding {
+				f, ok := opened[span.file.Name]
+				if !ok {
+					if f, err = os.Open(path(span.file.Name)); err != nil {
+						return
+					}
+					opened[span.file.Name] = f
+				}
+				// A short file cannot match, it will be fixed by the repair
+				if _, e := f.ReadAt(buf, span.offset); e != nil {
+					complete = false
+					break
+				}
+			}
+			h.Write(buf)
+		}
+		if !complete || !bytes.Equal(h.Sum(nil), l.metainfo.Pieces[piece]) {
+			failing = append(failing, piece)
+		}
+	}
+	return
+}
+
+// ranges merges the parts of the given pieces stored in real files.
+func (l pieceLayout) ranges(pieces []int) (ranges []fileRange) {
+	for _, piece := range pieces {
+		for _, span := range l.spans(piece) {
+			if span.file.Padding {
+				continue
+			}
+			if n := len(ranges); n > 0 && ranges[n-1].name == span.file.Name && ranges[n-1].offset+ranges[n-1].length == span.offset {
+				ranges[n-1].length += span.length
+				continue
+			}
+			ranges = append(ranges, fileRange{name: span.file.Name, offset: span.offset, length: span.length})
+		}
+	}
+	return
+}
@@ -0,0 +1,133 @@
+package task
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"seedbox-sync/model"
+	"seedbox-sync/notifier"
+	"seedbox-sync/provider"
+	"strings"
+	"testing"
+)
+
+// exportingProvider gives the .torrent file of its torrents, or err.
+type exportingProvider struct {
+	*fakeProvider
+	metainfo []byte
+	err      error
+}
+
+func (p *exportingProvider) ExportMetainfo(torrent provider.Torrent) ([]byte, error) {
+	return p.metainfo, p.err
+}
+
+// unverifiedNotifier records the torrents which could not be verified.
+type unverifiedNotifier struct {
+	*notifier.ComposeNotifier
+	unverified []string
+}
+
+func (n *unverifiedNotifier) Unverified(torrent provider.Torrent, reason string) {
+	n.unverified = append(n.unverified, torrent.Hash)
+}
+
+// testMetainfo builds the .torrent file of a torrent named name holding
+// files, concatenated in content, split into pieces of pieceLength bytes.
+func testMetainfo(name string, files []provider.TorrentFile, content []byte, pieceLength int) []byte {
+	var b strings.Builder
+	b.WriteString("d4:infod5:filesl")
+	for _, file := range files {
+		path := strings.TrimPrefix(file.Name, name+"/")
+		fmt.Fprintf(&b, "d6:lengthi%de4:pathl%d:%see", file.Length, len(path), path)
+	}
+	fmt.Fprintf(&b, "e4:name%d:%s12:piece lengthi%de6:pieces", len(name), name, pieceLength)
+	var pieces []byte
+	for start := 0; start < len(content); start += pieceLength {
+		end := start + pieceLength
+		if end > len(content) {
+			end = len(content)
+		}
+		sum := sha1.Sum(content[start:end])
+		pieces = append(pieces, sum[:]...)
+	}
+	fmt.Fprintf(&b, "%d:%see", len(pieces), pieces)
+	return []byte(b.String())
+}
+
+func TestVerifyTorrentRepairs(t *testing.T) {
+	st := newSyncTest(t)
+	movie := randomContent(t, 100<<10)
+	info := randomContent(t, 1000)
+	torrent := st.addTorrent("abcd", map[string][]byte{"Movie/a.mkv": movie})
+	torrent.Files = append(torrent.Files, provider.TorrentFile{Name: "Movie/b.nfo", Length: int64(len(info)), BytesCompleted: int64(len(info)), Wanted: true})
+	writeTestFile(t, filepath.Join(st.mount, "complete", "Movie/b.nfo"), info)
+	metainfo := testMetainfo("Movie", torrent.Files, append(append([]byte{}, movie...), info...), 16<<10)
+
+	// A piece in the middle of the first file and the piece shared by both
+	// are corrupted
+	corrupted := append([]byte{}, movie...)
+	corrupted[40<<10] ^= 0xff
+	corrupted[len(corrupted)-1] ^= 0xff
+	writeTestFile(t, filepath.Join(st.folder.LocalTempPath, "Movie/a.mkv"), corrupted)
+	writeTestFile(t, filepath.Join(st.folder.LocalTempPath, "Movie/b.nfo"), info)
+
+	s := NewSync([]model.Folder{st.folder}, st.transfer, &exportingProvider{fakeProvider: st.provider, metainfo: metainfo}, st.pool(), st.store, notifier.NewCompose(), os.Stdout)
+	repaired, err := s.verifyTorrent(torrent, torrent.Files, st.folder.LocalTempPath, st.folder.RemoteCompletePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The shared piece is fetched again in both files
+	if len(repaired) != 2 || repaired[0] != "Movie/a.mkv" || repaired[1] != "Movie/b.nfo" {
+		t.Errorf("repaired = %v, want [Movie/a.mkv Movie/b.nfo]", repaired)
+	}
+	assertContent(t, filepath.Join(st.folder.LocalTempPath, "Movie/a.mkv"), movie)
+	assertContent(t, filepath.Join(st.folder.LocalTempPath, "Movie/b.nfo"), info)
+}
+
+func TestSyncWithoutMetainfo(t *testing.T) {
+	st := newSyncTest(t)
+	st.folder.Verify = true
+	movie := randomContent(t, 10<<10)
+	st.addTorrent("abcd", map[string][]byte{"Movie/a.mkv": movie})
+
+	// The provider cannot give the .torrent file: the torrent is synchronised
+	// without verification, which is reported
+	n := &unverifiedNotifier{ComposeNotifier: notifier.NewCompose()}
+	NewSync([]model.Folder{st.folder}, st.transfer, st.provider, st.pool(), st.store, notifier.NewSynchronized(n), os.Stdout).Execute()
+
+	assertContent(t, filepath.Join(st.folder.LocalPostProcessingPath, "Movie/a.mkv"), movie)
+	if len(n.unverified) != 1 || n.unverified[0] != "abcd" {
+		t.Errorf("unverified = %v, want [abcd]", n.unverified)
+	}
+}
+
+func TestLoadMetainfoFailures(t *testing.T) {
+	st := newSyncTest(t)
+	torrent := st.addTorrent("abcd", map[string][]byte{"Movie/a.mkv": randomContent(t, 100)})
+	p := &exportingProvider{fakeProvider: st.provider, err: errors.New("connection reset")}
+	s := NewSync([]model.Folder{st.folder}, st.transfer, p, st.pool(), st.store, notifier.NewCompose(), os.Stdout)
+
+	// A failure to get the metainfo is retried by the next load
+	if _, err := s.loadMetainfo(torrent); err == nil || isUnverifiable(err) {
+		t.Errorf("error = %v, want a failure", err)
+	}
+	p.err = nil
+	p.metainfo = testMetainfo("Movie", torrent.Files, make([]byte, 100), 16<<10)
+	if _, err := s.loadMetainfo(torrent); err != nil {
+		t.Errorf("metainfo not loaded again: %v", err)
+	}
+
+	// Version 2 only torrents cannot be verified, and are not asked again
+	other := st.addTorrent("efgh", map[string][]byte{"Show/b.mkv": randomContent(t, 100)})
+	p.metainfo = []byte("d4:infod9:file treede4:name4:Show12:piece lengthi16384eee")
+	if _, err := s.loadMetainfo(other); !isUnverifiable(err) {
+		t.Errorf("error = %v, want unverifiable", err)
+	}
+	p.err = errors.New("not asked again")
+	if _, err := s.loadMetainfo(other); !isUnverifiable(err) {
+		t.Errorf("error = %v, want unverifiable", err)
+	}
+}